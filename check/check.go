@@ -0,0 +1,240 @@
+// Package check verifies that a running ring is consistent, using the
+// routing state its nodes serve at /admin/routing
+package check
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/hoffa2/chord/node"
+)
+
+// Kinds of violations
+const (
+	// Unreachable node whose routing state could not be fetched
+	Unreachable = "unreachable"
+	// Predecessor node whose predecessor does not point back along the walk
+	Predecessor = "predecessor"
+	// Loop successors that lead back to a node other than the start
+	Loop = "loop"
+	// Order successor pointers that wrap around the ID space more than once
+	Order = "order"
+	// Missing node registered at the nameserver but not in the ring
+	Missing = "missing"
+	// Finger finger entries differing from the true successor of their start
+	Finger = "finger"
+)
+
+// Violation One inconsistency found in the ring
+type Violation struct {
+	Kind   string
+	Node   string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%-12s %s: %s", v.Kind, v.Node, v.Detail)
+}
+
+// Fetcher Returns the routing state of the node at an RPC address
+type Fetcher func(addr string) (*node.RoutingState, error)
+
+// Config What to check and how to reach the nodes
+type Config struct {
+	// Start RPC address the walk begins at. Defaults to the first
+	// registered node
+	Start string
+	// Registered RPC addresses of the nodes expected in the ring
+	Registered []string
+	Fetch      Fetcher
+	// Fingers also compares every finger with the true successor of its start
+	Fingers bool
+}
+
+// Report Outcome of a check
+type Report struct {
+	// Ring RPC addresses in successor order, beginning at the start
+	Ring       []string
+	Violations []Violation
+}
+
+// OK reports whether no violations were found
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *Report) String() string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "Ring of %d nodes:\n", len(r.Ring))
+	for _, addr := range r.Ring {
+		fmt.Fprintf(b, "  %s\n", addr)
+	}
+	if r.OK() {
+		fmt.Fprintln(b, "No violations")
+		return b.String()
+	}
+	fmt.Fprintf(b, "%d violations:\n", len(r.Violations))
+	for _, v := range r.Violations {
+		fmt.Fprintf(b, "  %s\n", v)
+	}
+	return b.String()
+}
+
+type checker struct {
+	cfg    Config
+	report *Report
+	// Fetched states; nil for unreachable nodes
+	states map[string]*node.RoutingState
+}
+
+// Ring Walks the ring through successors and reports every violation
+func Ring(cfg Config) *Report {
+	c := &checker{cfg: cfg, report: &Report{}, states: make(map[string]*node.RoutingState)}
+	start := cfg.Start
+	if start == "" && len(cfg.Registered) > 0 {
+		start = cfg.Registered[0]
+	}
+	if start == "" {
+		c.violation(Unreachable, "-", "no node to start from")
+		return c.report
+	}
+
+	visited := c.walk(start)
+	for _, addr := range cfg.Registered {
+		if _, ok := visited[addr]; ok {
+			continue
+		}
+		if st := c.state(addr); st != nil {
+			c.violation(Missing, addr, "registered but not reached from %s", start)
+		}
+	}
+	if cfg.Fingers {
+		c.fingers()
+	}
+	return c.report
+}
+
+func (c *checker) violation(kind, addr, format string, args ...interface{}) {
+	c.report.Violations = append(c.report.Violations, Violation{kind, addr, fmt.Sprintf(format, args...)})
+}
+
+// state Fetches the routing state of addr once. Unreachable nodes are
+// reported and return nil
+func (c *checker) state(addr string) *node.RoutingState {
+	if st, ok := c.states[addr]; ok {
+		return st
+	}
+	st, err := c.cfg.Fetch(addr)
+	if err != nil {
+		c.violation(Unreachable, addr, "%v", err)
+		st = nil
+	}
+	c.states[addr] = st
+	return st
+}
+
+// walk Follows successors from start until it returns to start.
+// Returns the position of each node visited
+func (c *checker) walk(start string) map[string]int {
+	visited := make(map[string]int)
+	st := c.state(start)
+	if st == nil {
+		return visited
+	}
+	cur, wraps := start, 0
+	for {
+		visited[cur] = len(c.report.Ring)
+		c.report.Ring = append(c.report.Ring, cur)
+
+		next, nst := c.successor(st)
+		if nst == nil {
+			c.violation(Unreachable, cur, "no successor could be reached")
+			return visited
+		}
+		if nst.Predecessor.IP != cur {
+			c.violation(Predecessor, next, "predecessor is %q, but %s has it as successor", nst.Predecessor.IP, cur)
+		}
+		if id(nst.Node.ID).Cmp(id(st.Node.ID)) <= 0 {
+			wraps++
+			if wraps > 1 {
+				c.violation(Order, cur, "successor %s wraps around the ring a second time", next)
+			}
+		}
+		if next == start {
+			return visited
+		}
+		if i, ok := visited[next]; ok {
+			c.violation(Loop, next, "reached again after %d nodes without returning to %s", len(c.report.Ring)-i, start)
+			return visited
+		}
+		cur, st = next, nst
+	}
+}
+
+// successor Returns the first reachable node in the successor list of st
+func (c *checker) successor(st *node.RoutingState) (string, *node.RoutingState) {
+	if len(st.Successors) == 0 {
+		return st.Node.IP, st
+	}
+	for _, s := range st.Successors {
+		if nst := c.state(s.IP); nst != nil {
+			return s.IP, nst
+		}
+	}
+	return "", nil
+}
+
+// fingers Compares the fingers of every node in the ring with the
+// true successor of their start
+func (c *checker) fingers() {
+	var ring []*node.RoutingState
+	for _, addr := range c.report.Ring {
+		ring = append(ring, c.states[addr])
+	}
+	sort.Slice(ring, func(i, j int) bool { return id(ring[i].Node.ID).Cmp(id(ring[j].Node.ID)) < 0 })
+	// trueSuccessor first node at or after k
+	trueSuccessor := func(k *big.Int) *node.RoutingState {
+		i := sort.Search(len(ring), func(i int) bool { return id(ring[i].Node.ID).Cmp(k) >= 0 })
+		return ring[i%len(ring)]
+	}
+
+	space := new(big.Int).Lsh(big.NewInt(1), uint(node.KeySize))
+	for _, st := range ring {
+		base := id(st.Node.ID)
+		first, got, want := -1, "", ""
+		flush := func(last int) {
+			if first >= 0 {
+				c.violation(Finger, st.Node.IP, "fingers %d-%d point at %s, true successor is %s", first, last, got, want)
+			}
+			first = -1
+		}
+		for _, f := range st.Fingers {
+			for i := f.First; i <= f.Last; i++ {
+				k := new(big.Int).Add(base, new(big.Int).Lsh(big.NewInt(1), uint(i)))
+				w := trueSuccessor(k.Mod(k, space)).Node.IP
+				if f.Node.IP == "" || f.Node.IP == w {
+					flush(i - 1)
+					continue
+				}
+				if first >= 0 && (got != f.Node.IP || want != w) {
+					flush(i - 1)
+				}
+				if first < 0 {
+					first, got, want = i, f.Node.IP, w
+				}
+			}
+		}
+		flush(node.KeySize - 1)
+	}
+}
+
+// id Parses a hex encoded identifier
+func id(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	if n == nil {
+		return new(big.Int)
+	}
+	return n
+}
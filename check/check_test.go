@@ -0,0 +1,97 @@
+package check
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/node/testcluster"
+)
+
+// fakeRing Serves states keyed by address; others are unreachable
+type fakeRing map[string]*node.RoutingState
+
+func (f fakeRing) add(addr, id, pred, succ string, fingers ...node.FingerRange) {
+	f[addr] = &node.RoutingState{
+		Node:        node.Peer{IP: addr, ID: id},
+		Predecessor: node.Peer{IP: pred},
+		Successors:  []node.Peer{{IP: succ}},
+		Fingers:     fingers,
+	}
+}
+
+func (f fakeRing) fetch(addr string) (*node.RoutingState, error) {
+	if st, ok := f[addr]; ok {
+		return st, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func kinds(r *Report) map[string]int {
+	m := make(map[string]int)
+	for _, v := range r.Violations {
+		m[v.Kind]++
+	}
+	return m
+}
+
+func TestViolations(t *testing.T) {
+	f := fakeRing{}
+	f.add("a", "10", "c", "b")
+	f.add("b", "20", "a", "c")
+	f.add("c", "30", "b", "b")
+	f.add("e", "40", "e", "e")
+
+	r := Ring(Config{Registered: []string{"a", "b", "c", "d", "e"}, Fetch: f.fetch})
+	want := map[string]int{Predecessor: 1, Loop: 1, Unreachable: 1, Missing: 1}
+	got := kinds(r)
+	for k, n := range want {
+		if got[k] != n {
+			t.Fatalf("want %v, got:\n%s", want, r)
+		}
+	}
+	if len(r.Violations) != 4 {
+		t.Fatalf("unexpected violations:\n%s", r)
+	}
+}
+
+func TestFingerViolations(t *testing.T) {
+	f := fakeRing{}
+	b := node.Peer{IP: "b", ID: "20"}
+	f.add("a", "10", "c", "b", node.FingerRange{First: 0, Last: node.KeySize - 1, Node: b})
+	f.add("b", "20", "a", "c")
+	f.add("c", "30", "b", "a")
+
+	r := Ring(Config{Start: "a", Fetch: f.fetch, Fingers: true})
+	if len(r.Ring) != 3 || len(r.Violations) != 2 || kinds(r)[Finger] != 2 {
+		t.Fatalf("got:\n%s", r)
+	}
+	// 0x10 + 2^5 is owned by c, larger starts wrap around to a
+	if r.Violations[0].Detail != "fingers 5-5 point at b, true successor is c" ||
+		r.Violations[1].Detail != "fingers 6-159 point at b, true successor is a" {
+		t.Fatalf("got:\n%s", r)
+	}
+}
+
+func TestClusterIsConsistent(t *testing.T) {
+	c, err := testcluster.New(4, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := make(map[string]string)
+	var registered []string
+	for _, n := range c.Nodes {
+		addrs[n.IP] = n.HTTPAddr()
+		registered = append(registered, n.IP)
+	}
+	r := Ring(Config{Registered: registered, Fetch: HTTPFetcher(addrs, "", nil)})
+	if !r.OK() || len(r.Ring) != len(c.Nodes) {
+		t.Fatalf("got:\n%s", r)
+	}
+}
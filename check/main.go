@@ -0,0 +1,82 @@
+package check
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/urfave/cli"
+)
+
+// Run Checks the ring registered at the nameserver and prints the report.
+// Fails if any violation is found
+func Run(c *cli.Context) error {
+	addrs, err := netutils.GetNodeAddrs(netutils.WithPort(c.String("nameserver"), netutils.DefaultHTTPPort))
+	if err != nil {
+		return err
+	}
+	tlsConf, err := node.LoadTLSFlags(c)
+	if err != nil {
+		return err
+	}
+	cfg := Config{
+		Start:   c.String("node"),
+		Fetch:   HTTPFetcher(addrs, c.String("token"), tlsConf),
+		Fingers: !c.Bool("skip-fingers"),
+	}
+	for addr := range addrs {
+		cfg.Registered = append(cfg.Registered, addr)
+	}
+	sort.Strings(cfg.Registered)
+
+	r := Ring(cfg)
+	fmt.Print(r)
+	if !r.OK() {
+		return fmt.Errorf("ring check found %d violations", len(r.Violations))
+	}
+	return nil
+}
+
+// HTTPFetcher Fetches routing state from /admin/routing. addrs maps the
+// RPC address of each node to its HTTP API address; token is sent as
+// bearer token if set. Nodes are reached over HTTPS unless tlsConf is nil
+func HTTPFetcher(addrs map[string]string, token string, tlsConf *tls.Config) Fetcher {
+	client := http.Client{Timeout: time.Second * 2}
+	scheme := "http"
+	if tlsConf != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConf}
+		scheme = "https"
+	}
+	return func(addr string) (*node.RoutingState, error) {
+		httpAddr := addrs[addr]
+		if httpAddr == "" {
+			return nil, errors.New("HTTP address unknown to the nameserver")
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/admin/routing", scheme, httpAddr), nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("routing state refused: %s", resp.Status)
+		}
+		st := &node.RoutingState{}
+		if err := json.NewDecoder(resp.Body).Decode(st); err != nil {
+			return nil, err
+		}
+		return st, nil
+	}
+}
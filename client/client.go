@@ -16,7 +16,7 @@ import (
 )
 
 type Client struct {
-	// IP addresses to chord nodes
+	// HTTP addresses of chord nodes
 	IPs     []string
 	workers int
 	// Ip address of nameserver
@@ -44,7 +44,7 @@ func (c *Client) putKey(args interface{}) {
 	key := job.Key
 	nodeip := job.IP
 	val := job.Val
-	url := fmt.Sprintf("http://%s/%s", nodeip+":8030", key)
+	url := fmt.Sprintf("http://%s/%s", nodeip, key)
 	req, err := http.NewRequest("PUT", url, strings.NewReader(val))
 	if err != nil {
 		c.errors <- err
@@ -71,7 +71,7 @@ func (c *Client) getKey(args interface{}) {
 	job := args.(HTTPJob)
 	key := job.Key
 	nodeip := job.IP
-	url := fmt.Sprintf("http://%s/%s", nodeip+":8030", key)
+	url := fmt.Sprintf("http://%s/%s", nodeip, key)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		c.errors <- err
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/urfave/cli"
+)
+
+// Lookup Prints how a node routes the lookup of a key
+func Lookup(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("usage: lookup [--node addr] <key>")
+	}
+	base, err := nodeURL(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", base+"/_lookup/"+url.PathEscape(c.Args().First()), nil)
+	if err != nil {
+		return err
+	}
+	if t := c.String("token"); t != "" {
+		req.Header.Set("Authorization", "Bearer "+t)
+	}
+	resp, err := (&http.Client{Timeout: time.Second * 15}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var t node.LookupTrace
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return err
+	}
+	printTrace(&t)
+	if t.Error != "" {
+		return errors.New(t.Error)
+	}
+	return nil
+}
+
+// nodeURL Returns the base URL of the node given by --node, or of
+// the first node registered with the nameserver
+func nodeURL(c *cli.Context) (string, error) {
+	addr := c.String("node")
+	if addr == "" {
+		if !c.IsSet("nameserver") {
+			return "", errors.New("either --node or --nameserver must be set")
+		}
+		addrs, err := netutils.GetNodeHTTPAddrs(netutils.WithPort(c.String("nameserver"), netutils.DefaultHTTPPort))
+		if err != nil {
+			return "", err
+		}
+		if len(addrs) == 0 {
+			return "", errors.New("no nodes registered with the nameserver")
+		}
+		addr = addrs[0]
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+func printTrace(t *node.LookupTrace) {
+	fmt.Printf("key     %s\nid      %s\nnode    %s\n", t.Key, t.ID, t.Node)
+	if t.Owner != "" {
+		fmt.Printf("owner   %s (%s)\n", t.Owner, t.OwnerID)
+	}
+	fmt.Printf("took    %s, %d calls\n", t.Latency, len(t.Hops))
+	if len(t.Hops) == 0 {
+		return
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tPATH\tNODE\tRPC\tLATENCY\tNOTE")
+	for i, h := range t.Hops {
+		note := h.Error
+		if h.SkippedTo != "" {
+			note += "; skipped to " + h.SkippedTo
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", i+1, h.Path, h.Node, h.RPC, h.Latency, note)
+	}
+	w.Flush()
+}
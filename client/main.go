@@ -37,7 +37,7 @@ func Run(c *cli.Context) error {
 		key := RandStringBytes(30)
 		client.keyvalues[key] = key
 	}
-	ips, err := netutils.GetNodeIPs(nameServerAddr + ":" + "8030")
+	ips, err := netutils.GetNodeHTTPAddrs(netutils.WithPort(nameServerAddr, netutils.DefaultHTTPPort))
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,8 @@ type NodeComm interface {
 	FindPredecessor(args *Args, reply *NodeID) error
 	// FindSuccessor RPC call to find the Successor of an identifer
 	FindSuccessor(args *Args, reply *NodeID) error
+	// GetSuccessorList RPC call to get a nodes whole successor list
+	GetSuccessorList(args *Empty, reply *[]NodeID) error
 	// GetPredecessor RPC call to get a nodes predecessor
 	GetPredecessor(args *Empty, reply *NodeID) error
 	// GetSuccessor RPC call to get a nodes successor
@@ -18,14 +20,17 @@ type NodeComm interface {
 	UpdatePredecessor(args *NodeID, reply *Empty) error
 	// UpdateSUccessor updates a node's successor
 	UpdateSuccessor(args *NodeID, reply *Empty) error
-	// Init asserts RPC connection
-	Init(args *Args, reply *NodeID) error
+	// Init asserts RPC connection and checks that both ends are compatible
+	Init(args *Handshake, reply *Handshake) error
 	UpdateFingerTable(args *FingerEntry, reply *Empty) error
-	// ClosesPreFinger find the closeset predecesing finger in a node's fingertable
+	// ClosesPreFinger find the closeset predecesing finger in a node's fingertable.
+	// Kept taking a bare identifier for peers predating ClosestPreFingerArgs
 	ClosestPreFinger(id *string, reply *NodeID) error
+	// ClosestPreFingerArgs ClosestPreFinger of args.ID, carrying the trace context
+	ClosestPreFingerArgs(args *Args, reply *NodeID) error
 	GetKeysInInterval(ival *Interval, reply *Keys) error
 	// Notify RPC call to notify function as per Chord
-	Notify(node *Rnode, reply *Empty) error
+	Notify(node *Member, reply *Empty) error
 	// Leave called by an organizing entity to make a node leave the network
 	Leave(in *Empty, out *Empty) error
 }
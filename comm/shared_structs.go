@@ -1,22 +1,47 @@
 package comm
 
-import "github.com/hoffa2/chord/util"
+import (
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
 
 // Args arguments to an RPC
 type Args struct {
 	// Identifier of a node
 	ID string
+	// Timeout time the caller has left; zero means no deadline.
+	// Lookups pass what remains of it on to the next hop
+	Timeout time.Duration
+	TraceContext
+}
+
+// Handshake Exchanged by Init when a connection is set up. gob
+// matches fields by name, so peers predating it, which send Args
+// and answer with NodeID, still understand it
+type Handshake struct {
+	// ID "init", echoed back by every protocol version
+	ID string
+	// Version of the protocol spoken; zero for peers predating the handshake
+	Version int
+	// Hash and Bits how identifiers are derived
+	Hash string
+	Bits int
+	// Ring name of the ring the node belongs to
+	Ring string
 }
 
 // FingerEntry
 type FingerEntry struct {
 	S   NodeID
 	IDX int
+	TraceContext
 }
 
 type Interval struct {
 	From string
 	To   string
+	TraceContext
 }
 
 type Keys map[string]string
@@ -26,21 +51,63 @@ type Keys map[string]string
 type KeyValue struct {
 	Key   string
 	Value string
+	TraceContext
 }
 
 type Test struct {
 }
-type Empty struct{}
+
+// Empty Arguments of calls that take none but the trace context
+type Empty struct {
+	TraceContext
+}
 
 type NodeID struct {
 	ID string
+	// Full host:port RPC address of the node
+	IP string
+	// Proof of the cluster secret when a node is announced as a neighbor
+	Proof string
+	TraceContext
+}
+
+// Member A node announcing itself to its successor
+type Member struct {
+	ID util.Identifier
 	IP string
+	// Proof of the cluster secret
+	Proof string
+	TraceContext
+}
+
+// TraceContext Embedded in RPC arguments to carry the trace of the
+// request a call is made for. Not set on replies
+type TraceContext struct {
+	// Trace W3C traceparent of the caller's span; empty if untraced
+	Trace string `json:",omitempty"`
+}
+
+// SetTrace sets the traceparent sent with the call
+func (t *TraceContext) SetTrace(traceparent string) {
+	t.Trace = traceparent
+}
+
+// Traceparent Returns the traceparent the call was sent with
+func (t *TraceContext) Traceparent() string {
+	return t.Trace
+}
+
+// Traced Arguments carrying a trace context
+type Traced interface {
+	SetTrace(traceparent string)
+	Traceparent() string
 }
 
 type Rnodes []Rnode
 
 type Rnode struct {
 	ID util.Identifier
+	// Full host:port RPC address of the node, dialed as given
 	IP string
 }
 
@@ -3,13 +3,18 @@ package launch
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,14 +23,17 @@ import (
 
 	"github.com/hoffa2/chord/comm"
 	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
 	"github.com/hoffa2/chord/util"
 	"github.com/urfave/cli"
 )
 
 var (
-	RunNodeCmd       = "chord node --port 8030 --nameserver %s:8030"
-	RunClientCmd     = []string{"chord", "client", "--port 8000"}
-	RunNameServerCmd = "chord nameserver"
+	// RunNodeCmd takes the HTTP port, RPC port and nameserver address
+	RunNodeCmd   = "chord node --port %s --rpcport %s --nameserver %s"
+	RunClientCmd = []string{"chord", "client", "--port 8000"}
+	// RunNameServerCmd takes the port of the nameserver
+	RunNameServerCmd = "chord nameserver --port %s"
 	ListHosts        = "rocks_list_hosts.sh"
 	KILLED           = "KILLED"
 	ALIVE            = "ALIVE"
@@ -47,15 +55,32 @@ type NodeState struct {
 	Next       string
 	Prev       string
 	Successors comm.Rnodes
+	Failures   struct {
+		Total  int
+		Purged int
+	}
 }
 
 type Connection struct {
-	conns      []*SSHConn
-	freeNodes  []string
+	conns     []*SSHConn
+	freeNodes []string
+	// nameserver host the nameserver runs on, and its host:port
 	nameserver string
-	cwd        string
-	graph      int
-	logfile    *os.File
+	nsAddr     string
+	// Ports the nodes serve HTTP and RPC on
+	httpPort string
+	rpcPort  string
+	cwd      string
+	graph    int
+	logfile  *os.File
+	// API token sent to nodes; empty if they do not require one
+	token string
+	// Flags every started node is given, e.g. its ring and TLS files
+	nodeFlags string
+	// How the console reaches the nodes' APIs; TLS nil for plaintext
+	ring  string
+	codec netutils.Codec
+	tls   *tls.Config
 }
 
 var (
@@ -70,7 +95,7 @@ type Console struct {
 }
 
 func (c *Connection) ListNodes(args []string) error {
-	client := http.Client{Timeout: time.Duration(time.Second * 2)}
+	client := c.httpClient()
 	var n NodeState
 	fmt.Printf("Nodes Running: %d\n", len(c.conns)-1)
 	for _, node := range c.conns {
@@ -78,10 +103,11 @@ func (c *Connection) ListNodes(args []string) error {
 			continue
 		}
 
-		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:8030/state/get", node.host), nil)
+		req, err := http.NewRequest("GET", c.nodeURL(node.host, "state/get"), nil)
 		if err != nil {
 			return err
 		}
+		c.authorize(req)
 		resp, err := client.Do(req)
 		if err != nil {
 			fmt.Printf(Blue+"%s  "+Red+"KILLED\n"+White, node.host)
@@ -94,6 +120,7 @@ func (c *Connection) ListNodes(args []string) error {
 		}
 		fmt.Printf("Node: "+Blue+"%s"+White+" ==> ("+Green+"\t%s "+Red+"%s"+White+")\n",
 			n.IP, n.Prev, n.Next)
+		fmt.Printf("Failures: %d (purged %d)\n", n.Failures.Total, n.Failures.Purged)
 		fmt.Println("Successors:")
 		for _, succ := range n.Successors {
 			fmt.Printf("%s\n", succ.IP)
@@ -124,10 +151,10 @@ func (c *Console) RunConsole() {
 }
 
 func (c *Connection) AddNode(args []string) error {
-	nodecmd := fmt.Sprintf(RunNodeCmd, c.nameserver)
+	nodecmd := fmt.Sprintf(RunNodeCmd, c.httpPort, c.rpcPort, c.nsAddr)
 	node := c.freeNodes[len(c.freeNodes)-1]
 	c.freeNodes = c.freeNodes[:len(c.freeNodes)-1]
-	command := nodecmd
+	command := nodecmd + c.nodeFlags
 	if c.graph != 0 {
 		command += fmt.Sprintf(" --graph=%d", c.graph)
 	}
@@ -154,17 +181,46 @@ func (c *Connection) RunTests(args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("")
 	}
-	c.runCommand("chord", "client", fmt.Sprintf("--nameserver=%s", c.nameserver),
+	c.runCommand("chord", "client", fmt.Sprintf("--nameserver=%s", c.nsAddr),
 		fmt.Sprintf("--tests=%s", args[0]))
 	return nil
 }
 
+// nodeURL Returns the URL of path on the HTTP API of the node on host
+func (c *Connection) nodeURL(host, path string) string {
+	scheme := "http"
+	if c.tls != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, net.JoinHostPort(host, c.httpPort), path)
+}
+
+// httpClient Returns a client for the nodes' HTTP APIs
+func (c *Connection) httpClient() *http.Client {
+	client := &http.Client{Timeout: time.Duration(time.Second * 2)}
+	if c.tls != nil {
+		client.Transport = &http.Transport{TLSClientConfig: c.tls}
+	}
+	return client
+}
+
+// authorize adds the console's token to req
+func (c *Connection) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
 func (c *Connection) leaveNode(args []string) error {
-	noderpc, err := netutils.ConnectRPC(args[0])
-	if err != nil {
-		return err
+	if len(args) < 1 {
+		return fmt.Errorf("leave needs the name of a node")
+	}
+	var err error
+	if c.token != "" {
+		err = c.leaveHTTP(args[0])
+	} else {
+		err = c.leaveRPC(args[0])
 	}
-	err = noderpc.Call("NodeComm.Leave", &comm.Empty{}, &comm.Empty{})
 	if err != nil {
 		return err
 	}
@@ -176,6 +232,57 @@ func (c *Connection) leaveNode(args []string) error {
 	return nil
 }
 
+// leaveRPC asks a node that does not require authentication to leave
+func (c *Connection) leaveRPC(host string) error {
+	noderpc, err := netutils.ConnectRPC(net.JoinHostPort(host, c.rpcPort), c.ring, c.codec, c.tls)
+	if err != nil {
+		return err
+	}
+	return noderpc.Call(context.Background(), "NodeComm.Leave", &comm.Empty{}, &comm.Empty{})
+}
+
+// leaveHTTP asks a node to leave through its admin API
+func (c *Connection) leaveHTTP(host string) error {
+	client := c.httpClient()
+	req, err := http.NewRequest("POST", c.nodeURL(host, "admin/leave"), nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("leave refused by %s: %s", host, resp.Status)
+	}
+	return nil
+}
+
+// routingNode prints the full routing state of a node
+func (c *Connection) routingNode(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("routing needs the name of a node")
+	}
+	client := c.httpClient()
+	req, err := http.NewRequest("GET", c.nodeURL(args[0], "admin/routing?format=text"), nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("routing state refused by %s: %s", args[0], resp.Status)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
 func InitConsole(conn *Connection) *Console {
 	c := new(Console)
 	c.commands = make(map[string]cmdfunc)
@@ -184,12 +291,44 @@ func InitConsole(conn *Connection) *Console {
 	c.commands["add"] = conn.AddNode
 	c.commands["kill"] = conn.killNode
 	c.commands["leave"] = conn.leaveNode
+	c.commands["routing"] = conn.routingNode
 	c.commands["test"] = conn.RunTests
 	return c
 }
 
+// setNodeFlags Passes the ring, codec and TLS flags on to started nodes
+// and sets up the console to reach them the same way
+func (c *Connection) setNodeFlags(ctx *cli.Context) error {
+	var err error
+	if c.codec, err = netutils.ParseCodec(ctx.String("codec")); err != nil {
+		return err
+	}
+	if c.tls, err = node.LoadTLSFlags(ctx); err != nil {
+		return err
+	}
+	c.ring = ctx.String("ring")
+	for _, f := range []string{"ring", "codec", "tls-cert", "tls-key", "tls-ca"} {
+		if !ctx.IsSet(f) {
+			continue
+		}
+		v := ctx.String(f)
+		// Nodes are started in the parent of the working directory
+		if strings.HasPrefix(f, "tls-") {
+			if v, err = filepath.Abs(v); err != nil {
+				return err
+			}
+		}
+		c.nodeFlags += fmt.Sprintf(" --%s %s", f, v)
+	}
+	return nil
+}
+
 func Run(c *cli.Context) error {
-	nameserver := c.String("nameserver")
+	nsAddr := netutils.WithPort(c.String("nameserver"), netutils.DefaultHTTPPort)
+	nameserver, nsPort, err := net.SplitHostPort(nsAddr)
+	if err != nil {
+		return err
+	}
 	graph := c.Int("graph")
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -204,13 +343,22 @@ func Run(c *cli.Context) error {
 	conns := new(Connection)
 
 	// run nameserver
-	err = conns.runSSHCommand(nameserver, cwd, RunNameServerCmd)
+	err = conns.runSSHCommand(nameserver, cwd, fmt.Sprintf(RunNameServerCmd, nsPort))
 	if err != nil {
 		return err
 	}
 
 	conns.logfile = f
 	conns.nameserver = nameserver
+	conns.nsAddr = nsAddr
+	conns.httpPort = c.String("port")
+	if conns.httpPort == "" {
+		conns.httpPort = netutils.DefaultHTTPPort
+	}
+	conns.rpcPort = c.String("rpcport")
+	if conns.rpcPort == "" {
+		conns.rpcPort = netutils.DefaultRPCPort
+	}
 	conns.cwd = cwd
 
 	nodes, err := getNodeList("40")
@@ -220,6 +368,10 @@ func Run(c *cli.Context) error {
 
 	conns.freeNodes = nodes
 	conns.graph = graph
+	conns.token = c.String("token")
+	if err := conns.setNodeFlags(c); err != nil {
+		return err
+	}
 	cons := InitConsole(conns)
 	go cons.RunConsole()
 
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"log"
 	"os"
+	"time"
 
+	"github.com/hoffa2/chord/check"
 	"github.com/hoffa2/chord/client"
 	"github.com/hoffa2/chord/launch"
 	"github.com/hoffa2/chord/nameserver"
 	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/sim"
+	"github.com/hoffa2/chord/tracing"
 	"github.com/urfave/cli"
 )
 
@@ -34,7 +38,11 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "port, p",
-					Usage: "Specify port",
+					Usage: "Specify HTTP port (default 8030)",
+				},
+				cli.StringFlag{
+					Name:  "rpcport",
+					Usage: "Specify RPC port (default 8011)",
 				},
 				cli.StringFlag{
 					Name:  "nameserver, ns",
@@ -42,12 +50,81 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "id",
-					Usage: "optional id",
+					Usage: "optional hex encoded id; derived from the advertised address if not set",
+				},
+				cli.StringFlag{
+					Name:  "advertise",
+					Usage: "host:port other nodes should dial (default hostname:rpcport)",
 				},
 				cli.IntFlag{
 					Name:  "graph",
 					Usage: "graph on or of (1/0)",
 				},
+				cli.IntFlag{
+					Name:  "successors",
+					Usage: "length of the successor list",
+				},
+				cli.Float64Flag{
+					Name:  "phi",
+					Usage: "failure detector suspicion threshold (0 disables)",
+				},
+				cli.StringFlag{
+					Name:  "ring",
+					Usage: "name of the ring; peers on other rings are rejected",
+				},
+				cli.StringFlag{
+					Name:  "codec",
+					Usage: "wire codec of outgoing RPCs: gob, json or binary (default gob)",
+				},
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "PEM certificate of the node; enables mutual TLS for RPC and TLS for HTTP",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "PEM private key of the node",
+				},
+				cli.StringFlag{
+					Name:  "tls-ca",
+					Usage: "PEM certificate of the cluster CA peers are verified against",
+				},
+				cli.StringFlag{
+					Name:  "auth",
+					Usage: "JSON file of API tokens; requests without a valid token are refused",
+				},
+				cli.StringFlag{
+					Name:   "secret",
+					Usage:  "cluster secret required to join the ring",
+					EnvVar: "CHORD_SECRET",
+				},
+				cli.BoolFlag{
+					Name:  "strict-ids",
+					Usage: "only accept peers whose ID is the hash of their address",
+				},
+				cli.DurationFlag{
+					Name:  "probe",
+					Usage: "interval of checks that known peers are on the same ring (default 10s; negative disables)",
+				},
+				cli.IntFlag{
+					Name:  "lookup-parallel",
+					Usage: "number of lookups run in parallel from different fingers",
+				},
+				cli.IntFlag{
+					Name:  "lookup-quorum",
+					Usage: "parallel lookups that must agree (default majority; 1 takes the first answer)",
+				},
+				cli.Float64Flag{
+					Name:  "hedge",
+					Usage: "latency percentile after which a backup lookup is started, e.g. 0.95 (0 disables)",
+				},
+				cli.StringFlag{
+					Name:  "trace-file",
+					Usage: "file spans of traced requests are appended to (default tracing disabled)",
+				},
+				cli.StringFlag{
+					Name:  "trace-format",
+					Usage: "format of --trace-file: jsonl, read by the trace command, or otlp (default jsonl)",
+				},
 			},
 		},
 		{
@@ -74,12 +151,99 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:  "check",
+			Usage: "verify the consistency of the ring registered at the nameserver",
+			Action: func(c *cli.Context) error {
+				if !c.IsSet("nameserver") {
+					return errors.New("Nameserver flag must be set")
+				}
+				return check.Run(c)
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "nameserver, ns",
+					Usage: "address of nameserver",
+				},
+				cli.StringFlag{
+					Name:  "node",
+					Usage: "RPC address of the node the walk starts at (default first registered)",
+				},
+				cli.StringFlag{
+					Name:  "token",
+					Usage: "API token with admin rights, needed for nodes started with --auth",
+				},
+				cli.BoolFlag{
+					Name:  "skip-fingers",
+					Usage: "do not compare finger tables with the true successors",
+				},
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "PEM certificate presented to nodes started with TLS; reaches them over HTTPS",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "PEM private key of the certificate",
+				},
+				cli.StringFlag{
+					Name:  "tls-ca",
+					Usage: "PEM certificate of the cluster CA the nodes are verified against",
+				},
+			},
+		},
+		{
+			Name:      "lookup",
+			Usage:     "show the path a node takes to look up a key",
+			ArgsUsage: "<key>",
+			Action: func(c *cli.Context) error {
+				return client.Lookup(c)
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "node",
+					Usage: "HTTP address of the node running the lookup",
+				},
+				cli.StringFlag{
+					Name:  "nameserver, ns",
+					Usage: "address of nameserver; its first node is used if --node is not set",
+				},
+				cli.StringFlag{
+					Name:  "token",
+					Usage: "API token with read rights on the key",
+				},
+			},
+		},
+		{
+			Name:      "trace",
+			Usage:     "assemble a trace from the span files written by nodes",
+			ArgsUsage: "<trace id> [files...]",
+			Action: func(c *cli.Context) error {
+				return tracing.Run(c)
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory whose *.jsonl files are read if no files are given (default .)",
+				},
+			},
+		},
 		{
 			Name:  "nameserver",
 			Usage: "run nameserver",
 			Action: func(c *cli.Context) error {
 				return nameserver.Run(c)
 			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "port, p",
+					Usage: "Specify port (default 8030)",
+				},
+				cli.StringFlag{
+					Name:   "secret",
+					Usage:  "cluster secret nodes must prove to register",
+					EnvVar: "CHORD_SECRET",
+				},
+			},
 		},
 		{
 			Name:  "runall",
@@ -90,7 +254,15 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "nameserver, ns",
-					Usage: "address of nameserver",
+					Usage: "host[:port] of the nameserver to start (default port 8030)",
+				},
+				cli.StringFlag{
+					Name:  "port, p",
+					Usage: "HTTP port of the nodes (default 8030)",
+				},
+				cli.StringFlag{
+					Name:  "rpcport",
+					Usage: "RPC port of the nodes (default 8011)",
 				},
 				cli.StringFlag{
 					Name:  "hosts",
@@ -100,6 +272,76 @@ func main() {
 					Name:  "graph",
 					Usage: "0/1",
 				},
+				cli.StringFlag{
+					Name:  "token",
+					Usage: "API token with admin rights, needed for nodes started with --auth",
+				},
+				cli.StringFlag{
+					Name:  "ring",
+					Usage: "name of the ring the nodes form",
+				},
+				cli.StringFlag{
+					Name:  "codec",
+					Usage: "wire codec of the nodes' RPCs: gob, json or binary (default gob)",
+				},
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "PEM certificate; starts the nodes with mutual TLS and reaches them over HTTPS",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "PEM private key of the certificate",
+				},
+				cli.StringFlag{
+					Name:  "tls-ca",
+					Usage: "PEM certificate of the cluster CA",
+				},
+			},
+		},
+		{
+			Name:  "sim",
+			Usage: "simulate a ring on a virtual clock",
+			Action: func(c *cli.Context) error {
+				return sim.Run(c)
+			},
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "nodes, n",
+					Usage: "size of the initial ring (default 100)",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "seed of the run",
+				},
+				cli.DurationFlag{
+					Name:  "duration",
+					Usage: "virtual time to simulate (default 10m)",
+				},
+				cli.IntFlag{
+					Name:  "successors",
+					Usage: "length of the successor list",
+				},
+				cli.DurationFlag{
+					Name:  "stabilize",
+					Usage: "stabilize interval (default 1s)",
+				},
+				cli.DurationFlag{
+					Name:  "latency",
+					Usage: "latency of one call",
+				},
+				cli.DurationFlag{
+					Name:  "jitter",
+					Usage: "random extra latency of one call",
+				},
+				cli.DurationFlag{
+					Name:  "lookups",
+					Usage: "virtual time between lookups (0 disables)",
+					Value: time.Millisecond * 100,
+				},
+				cli.StringSliceFlag{
+					Name:  "churn",
+					Usage: "scripted churn AT:JOIN:FAIL:LEAVE, e.g. 5m:100:20:0",
+				},
 			},
 		},
 	}
@@ -0,0 +1,255 @@
+// Package metrics keeps counters, gauges and histograms and writes them
+// in the Prometheus text exposition format, so a node can be scraped
+// without pulling in a client library
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// DurationBuckets upper bounds in seconds, from 1ms to 10s
+	DurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// CountBuckets upper bounds for small counts such as lookup hops
+	CountBuckets = []float64{0, 1, 2, 3, 4, 6, 8, 12, 16, 24, 32}
+)
+
+// family One metric name and all its series
+type family interface {
+	write(w io.Writer)
+}
+
+// Registry Metrics written together, in the order they were registered
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+}
+
+// WriteText Writes every metric in the text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(b)
+	}
+	return b.Flush()
+}
+
+// ServeHTTP Serves the metrics to a scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteText(w)
+}
+
+// vec Series of one metric keyed by their label values
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{name: name, help: help, kind: kind, labels: labels, series: make(map[string][]string)}
+}
+
+// key Returns the key of the series with values, remembering the values.
+// Expects mu to be held
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := v.series[k]; !ok {
+		v.series[k] = append([]string(nil), values...)
+	}
+	return k
+}
+
+// sortedKeys Returns the series keys in order. Expects mu to be held
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+// labelPairs Formats the labels of a series, plus any extra pairs
+func (v *vec) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range v.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter Monotonically increasing values, one per set of label values
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels), values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Add adds d to the series with values
+func (c *Counter) Add(d float64, values ...string) {
+	c.mu.Lock()
+	c.values[c.key(values)] += d
+	c.mu.Unlock()
+}
+
+// Inc adds one to the series with values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(c.series[k]), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge A value read when the metrics are written
+type Gauge struct {
+	vec
+	f func() float64
+}
+
+// Gauge registers a gauge without labels whose value is f()
+func (r *Registry) Gauge(name, help string, f func() float64) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", nil), f: f}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// Histogram Observations counted into buckets, one set per label values
+type Histogram struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+// Histogram registers a histogram with the given bucket upper bounds
+// and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: append([]float64(nil), buckets...),
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+	sort.Float64s(h.buckets)
+	r.register(h)
+	return h
+}
+
+// Observe adds x to the series with values
+func (h *Histogram) Observe(x float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(values)
+	counts, ok := h.counts[k]
+	if !ok {
+		// The last count is the +Inf bucket
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[k] = counts
+	}
+	i := sort.SearchFloat64s(h.buckets, x)
+	counts[i]++
+	h.sums[k] += x
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		values := h.series[k]
+		var total uint64
+		for i, c := range h.counts[k] {
+			total += c
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values, "le", le), total)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(values), formatFloat(h.sums[k]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(values), total)
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("calls_total", "Calls made", "method", "peer")
+	c.Inc("Get", "b:1")
+	c.Add(2, "Get", "a:1")
+	c.Inc("Put", `say "hi"`)
+	r.Gauge("keys", "Keys\nstored", func() float64 { return 3 })
+	h := r.Histogram("hops", "Hops", []float64{1, 4}, "kind")
+	h.Observe(1, "x")
+	h.Observe(3, "x")
+	h.Observe(9, "x")
+	r.Counter("bytes_total", "Bytes")
+
+	want := `# HELP calls_total Calls made
+# TYPE calls_total counter
+calls_total{method="Get",peer="a:1"} 2
+calls_total{method="Get",peer="b:1"} 1
+calls_total{method="Put",peer="say \"hi\""} 1
+# HELP keys Keys\nstored
+# TYPE keys gauge
+keys 3
+# HELP hops Hops
+# TYPE hops histogram
+hops_bucket{kind="x",le="1"} 1
+hops_bucket{kind="x",le="4"} 2
+hops_bucket{kind="x",le="+Inf"} 3
+hops_sum{kind="x"} 13
+hops_count{kind="x"} 3
+# HELP bytes_total Bytes
+# TYPE bytes_total counter
+bytes_total 0
+`
+	b := new(bytes.Buffer)
+	if err := r.WriteText(b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+}
@@ -1,23 +1,34 @@
 package nameserver
 
 import (
+	"encoding/hex"
+	"log"
 	"net/http"
 	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/hoffa2/chord/netutils"
 	"github.com/hoffa2/chord/util"
 	"github.com/urfave/cli"
 )
 
 const (
 	Ip   = "ip"
+	HTTP = "http"
 	NoIp = "No IpAddress present in query"
 )
 
 type NameServer struct {
+	// RPC addresses of registered nodes
 	IpAdresses []string
-	mu         sync.RWMutex
-	states     []NodeState
+	// HTTP API address of each node, keyed by RPC address
+	httpAddrs map[string]string
+	mu        sync.RWMutex
+	states    []NodeState
+	// Cluster secret registrations must prove; empty admits everyone
+	secret string
+	// Registrations and unregistrations rejected for lacking proof of the secret
+	rejected int
 }
 
 type NodeState struct {
@@ -32,40 +43,66 @@ func Run(c *cli.Context) error {
 		port = "8030"
 	}
 
-	ns := &NameServer{}
+	ns := &NameServer{httpAddrs: make(map[string]string), secret: c.String("secret")}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/", ns.GetNodeList).Methods("GET")
+	r.HandleFunc("/http", ns.GetHTTPList).Methods("GET")
+	r.HandleFunc("/addrs", ns.getAddrs).Methods("GET")
 	r.HandleFunc("/unregister", ns.unRegister).Methods("POST")
 	r.HandleFunc("/", ns.registerNode).Methods("POST")
 	r.HandleFunc("/nodes", ns.getNodeState).Methods("GET")
+	r.HandleFunc("/rejected", ns.getRejected).Methods("GET")
 	return http.ListenAndServe(":"+port, r)
 }
 
-// Post request to register a node with the namserver
-func (n *NameServer) registerNode(w http.ResponseWriter, r *http.Request) {
+// admit Returns the ip a request registering or unregistering a node
+// is about, or "" after answering the request if it lacks the ip or
+// proof of the cluster secret for it
+func (n *NameServer) admit(w http.ResponseWriter, r *http.Request, action string) string {
 	ip := r.PostFormValue("ip")
 	if len(ip) == 0 {
 		util.ErrorResponse(w, NoIp)
+		return ""
+	}
+	id, err := hex.DecodeString(r.PostFormValue("id"))
+	if err != nil || !netutils.VerifyJoinProof(n.secret, id, ip, r.PostFormValue("proof")) {
+		n.mu.Lock()
+		n.rejected++
+		n.mu.Unlock()
+		log.Printf("Rejected %s of %s from %s: %v\n", action, ip, r.RemoteAddr, netutils.ErrNotAdmitted)
+		http.Error(w, netutils.ErrNotAdmitted.Error(), http.StatusForbidden)
+		return ""
+	}
+	return ip
+}
+
+// Post request to register a node with the namserver
+func (n *NameServer) registerNode(w http.ResponseWriter, r *http.Request) {
+	ip := n.admit(w, r, "registration")
+	if ip == "" {
 		return
 	}
 	n.mu.Lock()
 	n.IpAdresses = append(n.IpAdresses, ip)
+	if addr := r.PostFormValue(HTTP); len(addr) != 0 {
+		n.httpAddrs[ip] = addr
+	}
 	n.mu.Unlock()
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// Called when a node leaves the ring
+// Called when a node leaves the ring. Needs the same proof as registering
 func (n *NameServer) unRegister(w http.ResponseWriter, r *http.Request) {
-	ip := r.PostFormValue("ip")
-	if len(ip) == 0 {
-		util.ErrorResponse(w, NoIp)
+	ip := n.admit(w, r, "unregistration")
+	if ip == "" {
 		return
 	}
 	n.mu.Lock()
-	defer n.mu.Lock()
+	defer n.mu.Unlock()
 
+	delete(n.httpAddrs, ip)
 	for i, node := range n.IpAdresses {
 		if node == ip {
 			n.IpAdresses = append(n.IpAdresses[:i], n.IpAdresses[i+1:]...)
@@ -80,6 +117,37 @@ func (n *NameServer) GetNodeList(w http.ResponseWriter, r *http.Request) {
 	n.mu.RUnlock()
 }
 
+// GetHTTPList lists the HTTP API addresses clients should use
+func (n *NameServer) GetHTTPList(w http.ResponseWriter, r *http.Request) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	addrs := make([]string, 0, len(n.IpAdresses))
+	for _, ip := range n.IpAdresses {
+		if addr, ok := n.httpAddrs[ip]; ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	util.WriteJson(w, addrs)
+}
+
+// getAddrs maps the RPC address of every node to its HTTP API address
+func (n *NameServer) getAddrs(w http.ResponseWriter, r *http.Request) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	addrs := make(map[string]string, len(n.IpAdresses))
+	for _, ip := range n.IpAdresses {
+		addrs[ip] = n.httpAddrs[ip]
+	}
+	util.WriteJson(w, addrs)
+}
+
+// getRejected reports the number of rejected registrations and unregistrations
+func (n *NameServer) getRejected(w http.ResponseWriter, r *http.Request) {
+	n.mu.RLock()
+	util.WriteJson(w, n.rejected)
+	n.mu.RUnlock()
+}
+
 func (n *NameServer) getNodeState(w http.ResponseWriter, r *http.Request) {
 	n.mu.RLock()
 	util.WriteJson(w, n.states)
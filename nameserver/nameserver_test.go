@@ -0,0 +1,41 @@
+package nameserver
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/util"
+)
+
+func post(h http.HandlerFunc, form url.Values) int {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w.Code
+}
+
+func TestUnregisterNeedsProof(t *testing.T) {
+	ns := &NameServer{httpAddrs: make(map[string]string), secret: "s"}
+	ip := "10.0.0.1:8011"
+	id := util.StringToID(util.HashValue(ip))
+	signed := url.Values{"ip": {ip}, "id": {hex.EncodeToString(id)}, "proof": {netutils.JoinProof("s", id, ip)}}
+	if code := post(ns.registerNode, signed); code != http.StatusOK {
+		t.Fatalf("registration refused with %d", code)
+	}
+
+	if code := post(ns.unRegister, url.Values{"ip": {ip}}); code != http.StatusForbidden {
+		t.Errorf("expected an unproven unregistration to be refused, got %d", code)
+	}
+	if len(ns.IpAdresses) != 1 || ns.rejected != 1 {
+		t.Fatalf("expected the node to stay registered, got %v", ns.IpAdresses)
+	}
+	if code := post(ns.unRegister, signed); code != http.StatusOK || len(ns.IpAdresses) != 0 {
+		t.Errorf("expected the node to be unregistered, got %d and %v", code, ns.IpAdresses)
+	}
+}
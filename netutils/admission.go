@@ -0,0 +1,35 @@
+package netutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/hoffa2/chord/util"
+)
+
+var (
+	// ErrNotAdmitted if a node announced itself without proof of the cluster secret
+	ErrNotAdmitted = errors.New("not admitted: missing or invalid proof of the cluster secret")
+)
+
+// JoinProof Proves knowledge of the cluster secret for the node
+// id at ip. Nodes send it when they register with the nameserver
+// and when they announce themselves or others as neighbors
+func JoinProof(secret string, id util.Identifier, ip string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chord-join\n"))
+	mac.Write(id)
+	mac.Write([]byte("\n" + ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyJoinProof reports whether proof was made with secret for id and ip.
+// An empty secret admits everyone
+func VerifyJoinProof(secret string, id util.Identifier, ip, proof string) bool {
+	if secret == "" {
+		return true
+	}
+	return hmac.Equal([]byte(proof), []byte(JoinProof(secret, id, ip)))
+}
@@ -0,0 +1,436 @@
+package netutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/rpc"
+	"reflect"
+)
+
+// maxFrame bounds the size of a single binary codec frame
+const maxFrame = 64 << 20
+
+var (
+	errFrameTooLarge = errors.New("binary codec: frame too large")
+	errTruncated     = errors.New("binary codec: truncated frame")
+)
+
+// The binary codec sends every header and body as a frame: its
+// length as a uvarint followed by the encoded value. Values are
+// encoded positionally without type information: integers as
+// varints, strings, byte slices, slices and maps prefixed with
+// their length, pointers with a presence byte and structs as their
+// exported fields in order. Both ends must therefore share the
+// definitions in package comm, so dialRPC only keeps binary
+// connections to peers announcing exactly this build's version
+
+// appendUvarint appends x as a uvarint
+func appendUvarint(b []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
+
+// appendValue appends the encoding of v
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := v.Int()
+		return appendUvarint(b, uint64(x<<1)^uint64(x>>63)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v.Float()))
+		return append(b, tmp[:]...), nil
+	case reflect.String:
+		b = appendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = appendUvarint(b, uint64(v.Len()))
+			return append(b, v.Bytes()...), nil
+		}
+		b = appendUvarint(b, uint64(v.Len()))
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendValue(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		b = appendUvarint(b, uint64(v.Len()))
+		it := v.MapRange()
+		for it.Next() {
+			if b, err = appendValue(b, it.Key()); err != nil {
+				return nil, err
+			}
+			if b, err = appendValue(b, it.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(b, 0), nil
+		}
+		return appendValue(append(b, 1), v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if b, err = appendValue(b, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("binary codec: cannot encode %s", v.Type())
+}
+
+// decoder Reads values from a frame
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.b = d.b[n:]
+	return x, nil
+}
+
+// length reads a length prefix that cannot exceed the bytes left
+func (d *decoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.b)) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (d *decoder) bytes(n int) []byte {
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+// left returns how many values encoded in at least size bytes
+// the rest of the frame can hold
+func (d *decoder) left(size int) int {
+	if size < 1 {
+		size = 1
+	}
+	return len(d.b) / size
+}
+
+// minEncoded returns the fewest bytes a value of type t is encoded in
+func minEncoded(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return 8
+	case reflect.Array:
+		return t.Len() * minEncoded(t.Elem())
+	case reflect.Struct:
+		n := 0
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				n += minEncoded(t.Field(i).Type)
+			}
+		}
+		return n
+	}
+	// Varints, length prefixes and presence bytes
+	return 1
+}
+
+// value decodes into v, which must be settable
+func (d *decoder) value(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(d.b) < 1 {
+			return errTruncated
+		}
+		v.SetBool(d.bytes(1)[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(x>>1) ^ -int64(x&1))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		if len(d.b) < 8 {
+			return errTruncated
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(d.bytes(8))))
+	case reflect.String:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(d.bytes(n)))
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), d.bytes(n)...))
+			return nil
+		}
+		// A forged count must not allocate more than the frame holds
+		if n > d.left(minEncoded(v.Type().Elem())) {
+			return errTruncated
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.value(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		t := v.Type()
+		if n > d.left(minEncoded(t.Key())+minEncoded(t.Elem())) {
+			return errTruncated
+		}
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			val := reflect.New(t.Elem()).Elem()
+			if err := d.value(val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		if len(d.b) < 1 {
+			return errTruncated
+		}
+		if d.bytes(1)[0] == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.value(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("binary codec: cannot decode %s", v.Type())
+	}
+	return nil
+}
+
+// appendFrame appends a frame holding x, an RPC header,
+// argument or reply, which net/rpc passes as pointers
+func appendFrame(b []byte, x interface{}) ([]byte, error) {
+	// Reserve room for the longest length prefix and move the
+	// value up against the actual prefix once its length is known
+	start := len(b)
+	b = append(b, make([]byte, binary.MaxVarintLen32)...)
+	v := reflect.ValueOf(x)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+			continue
+		}
+		v = v.Elem()
+	}
+	var err error
+	if v.IsValid() {
+		if b, err = appendValue(b, v); err != nil {
+			return nil, err
+		}
+	}
+	body := len(b) - start - binary.MaxVarintLen32
+	if body > maxFrame {
+		return nil, errFrameTooLarge
+	}
+	var tmp [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(tmp[:], uint64(body))
+	copy(b[start+binary.MaxVarintLen32-n:], tmp[:n])
+	return append(b[:start], b[start+binary.MaxVarintLen32-n:]...), nil
+}
+
+// frameReader Reads frames from a connection
+type frameReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// next Returns the next frame. It is only valid until the following call
+func (f *frameReader) next() (*decoder, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFrame {
+		return nil, errFrameTooLarge
+	}
+	if uint64(cap(f.buf)) < n {
+		f.buf = make([]byte, n)
+	}
+	f.buf = f.buf[:n]
+	if _, err := io.ReadFull(f.r, f.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &decoder{b: f.buf}, nil
+}
+
+// body Decodes the next frame into x, or skips it if x is nil
+func (f *frameReader) body(x interface{}) error {
+	d, err := f.next()
+	if err != nil || x == nil {
+		return err
+	}
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("binary codec: cannot decode into %T", x)
+	}
+	return d.value(v.Elem())
+}
+
+// binaryServerCodec rpc.ServerCodec of CodecBinary
+type binaryServerCodec struct {
+	frameReader
+	rwc io.ReadWriteCloser
+	w   *bufio.Writer
+	out []byte
+}
+
+func newBinaryServerCodec(rwc io.ReadWriteCloser) *binaryServerCodec {
+	return &binaryServerCodec{
+		frameReader: frameReader{r: bufio.NewReader(rwc)},
+		rwc:         rwc,
+		w:           bufio.NewWriter(rwc),
+	}
+}
+
+func (c *binaryServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.body(r)
+}
+
+func (c *binaryServerCodec) ReadRequestBody(x interface{}) error {
+	return c.body(x)
+}
+
+// WriteResponse is serialized by net/rpc
+func (c *binaryServerCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	b, err := appendFrame(c.out[:0], r)
+	if err == nil {
+		b, err = appendFrame(b, x)
+	}
+	if err != nil {
+		// Let the client know instead of leaving it waiting
+		r.Error = err.Error()
+		if b, err = appendFrame(c.out[:0], r); err != nil {
+			return err
+		}
+		b, _ = appendFrame(b, nil)
+	}
+	c.out = b
+	if _, err := c.w.Write(b); err != nil {
+		c.Close()
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *binaryServerCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// binaryClientCodec rpc.ClientCodec of CodecBinary
+type binaryClientCodec struct {
+	frameReader
+	rwc io.ReadWriteCloser
+	w   *bufio.Writer
+	out []byte
+}
+
+func newBinaryClientCodec(rwc io.ReadWriteCloser) *binaryClientCodec {
+	return &binaryClientCodec{
+		frameReader: frameReader{r: bufio.NewReader(rwc)},
+		rwc:         rwc,
+		w:           bufio.NewWriter(rwc),
+	}
+}
+
+// WriteRequest is serialized by net/rpc
+func (c *binaryClientCodec) WriteRequest(r *rpc.Request, x interface{}) error {
+	b, err := appendFrame(c.out[:0], r)
+	if err != nil {
+		return err
+	}
+	if b, err = appendFrame(b, x); err != nil {
+		return err
+	}
+	c.out = b
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *binaryClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.body(r)
+}
+
+func (c *binaryClientCodec) ReadResponseBody(x interface{}) error {
+	return c.body(x)
+}
+
+func (c *binaryClientCodec) Close() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,219 @@
+package netutils
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+var (
+	// ErrCircuitOpen if a call was not attempted because its peer's circuit is open
+	ErrCircuitOpen = errors.New("circuit open: peer is considered down")
+)
+
+// BreakerConfig Tunes the per-peer circuit breakers. Zero values pick the defaults
+type BreakerConfig struct {
+	// Failures consecutive failed calls that open a peer's circuit.
+	// A negative value disables the breakers
+	Failures int
+	// Cooldown time an open circuit waits before letting a trial call through
+	Cooldown time.Duration
+}
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = time.Second * 5
+)
+
+func (c *BreakerConfig) setDefaults() {
+	if c.Failures == 0 {
+		c.Failures = defaultBreakerFailures
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = defaultBreakerCooldown
+	}
+}
+
+// BreakerState State of a peer's circuit
+type BreakerState int
+
+const (
+	// BreakerClosed calls go through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen calls fail fast with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen a single trial call decides whether to close again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// BreakerStats Counters of circuit breaker activity
+type BreakerStats struct {
+	// Open peers whose circuit is currently open
+	Open int
+	// Transitions into each state
+	Opened   int
+	HalfOpen int
+	Closed   int
+	// Rejected calls failed fast
+	Rejected int
+}
+
+// circuit Breaker state of one peer
+type circuit struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// set while the half-open trial call is in flight
+	trial bool
+}
+
+// breakers Circuit breakers of all peers
+type breakers struct {
+	sync.Mutex
+	cfg      BreakerConfig
+	clock    util.Clock
+	log      *log.Logger
+	circuits map[string]*circuit
+	stats    BreakerStats
+}
+
+func newBreakers(cfg BreakerConfig, clock util.Clock, l *log.Logger) *breakers {
+	cfg.setDefaults()
+	return &breakers{
+		cfg:      cfg,
+		clock:    clock,
+		log:      l,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// transition Moves c to state, logging and counting the change. Expects b to be locked
+func (b *breakers) transition(peer string, c *circuit, state BreakerState) {
+	if b.log != nil {
+		b.log.Printf("Circuit to %s %s -> %s\n", peer, c.state, state)
+	}
+	c.state = state
+	switch state {
+	case BreakerOpen:
+		c.openedAt = b.clock.Now()
+		b.stats.Opened++
+	case BreakerHalfOpen:
+		b.stats.HalfOpen++
+	case BreakerClosed:
+		c.failures = 0
+		b.stats.Closed++
+	}
+}
+
+// allow reports whether a call to peer may be attempted. Once an open
+// circuit has cooled down a single trial call is let through
+func (b *breakers) allow(peer string) bool {
+	if b.cfg.Failures < 0 {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[peer]
+	if !ok {
+		return true
+	}
+	switch c.state {
+	case BreakerOpen:
+		if b.clock.Now().Sub(c.openedAt) < b.cfg.Cooldown {
+			b.stats.Rejected++
+			return false
+		}
+		b.transition(peer, c, BreakerHalfOpen)
+		c.trial = true
+		return true
+	case BreakerHalfOpen:
+		if c.trial {
+			b.stats.Rejected++
+			return false
+		}
+		c.trial = true
+	}
+	return true
+}
+
+// success closes peer's circuit
+func (b *breakers) success(peer string) {
+	if b.cfg.Failures < 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[peer]
+	if !ok {
+		return
+	}
+	c.trial = false
+	if c.state != BreakerClosed {
+		b.transition(peer, c, BreakerClosed)
+	}
+	delete(b.circuits, peer)
+}
+
+// failure counts a failed call to peer, opening its circuit after
+// cfg.Failures in a row or when a trial call fails
+func (b *breakers) failure(peer string) {
+	if b.cfg.Failures < 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[peer]
+	if !ok {
+		c = &circuit{}
+		b.circuits[peer] = c
+	}
+	c.trial = false
+	c.failures++
+	if c.state == BreakerHalfOpen || (c.state == BreakerClosed && c.failures >= b.cfg.Failures) {
+		b.transition(peer, c, BreakerOpen)
+	}
+}
+
+// release ends a trial call whose outcome says nothing about peer
+func (b *breakers) release(peer string) {
+	b.Lock()
+	defer b.Unlock()
+	if c, ok := b.circuits[peer]; ok {
+		c.trial = false
+	}
+}
+
+// open reports whether peer's circuit is open and still cooling down.
+// Afterwards callers may pick the peer again, so that a trial call
+// can find out whether it has recovered
+func (b *breakers) open(peer string) bool {
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[peer]
+	return ok && c.state == BreakerOpen && b.clock.Now().Sub(c.openedAt) < b.cfg.Cooldown
+}
+
+func (b *breakers) counts() BreakerStats {
+	b.Lock()
+	defer b.Unlock()
+	s := b.stats
+	for _, c := range b.circuits {
+		if c.state == BreakerOpen {
+			s.Open++
+		}
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package netutils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemNetwork(1)
+	a, _ := listenStub(t, m, "")
+	clock := util.NewFakeClock(time.Now())
+	r := NewRemote(a, nil, NewPhiDetector(DefaultPhiThreshold, clock), RemoteConfig{
+		Retry:   RetryPolicy{Attempts: 1},
+		Breaker: BreakerConfig{Failures: 2, Cooldown: time.Second},
+		Clock:   clock,
+	})
+	peer := comm.Rnode{IP: "127.0.0.1:9000"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.GetSuccessor(ctx, peer); err != ErrUnreachable {
+			t.Fatalf("expected ErrUnreachable, got %v", err)
+		}
+	}
+	if _, err := r.GetSuccessor(ctx, peer); err != ErrCircuitOpen {
+		t.Fatalf("expected the circuit to be open, got %v", err)
+	}
+	if !r.Suspect(peer) {
+		t.Error("peer with an open circuit should be suspected")
+	}
+
+	// After the cooldown a trial call closes the circuit again
+	if _, err := m.Transport().Listen(peer.IP, &stubNode{succ: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	if _, err := r.GetSuccessor(ctx, peer); err != nil {
+		t.Fatalf("expected the trial call to succeed, got %v", err)
+	}
+	s := r.BreakerStats()
+	if s.Open != 0 || s.Opened != 1 || s.HalfOpen != 1 || s.Closed != 1 || s.Rejected != 1 {
+		t.Errorf("unexpected breaker stats %+v", s)
+	}
+}
+
+func TestRemoteRetriesTransientErrors(t *testing.T) {
+	m := NewMemNetwork(1)
+	a, _ := listenStub(t, m, "")
+	_, baddr := listenStub(t, m, "b-succ")
+	m.SetDropRate(1, 0)
+
+	failures := 0
+	r := NewRemote(a, func(rn *comm.Rnode, err error) { failures++ },
+		NewPhiDetector(DefaultPhiThreshold, util.RealClock{}), RemoteConfig{
+			Retry:   RetryPolicy{Attempts: 3, MinBackoff: time.Millisecond},
+			Breaker: BreakerConfig{Failures: -1},
+		})
+	if _, err := r.GetSuccessor(context.Background(), comm.Rnode{IP: baddr}); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if failures != 3 {
+		t.Errorf("expected 3 attempts, got %d", failures)
+	}
+
+	// Non-idempotent calls are not repeated
+	failures = 0
+	r.Notify(context.Background(), comm.Rnode{IP: baddr}, &comm.Rnode{})
+	if failures != 1 {
+		t.Errorf("expected a single attempt, got %d", failures)
+	}
+}
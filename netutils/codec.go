@@ -0,0 +1,97 @@
+package netutils
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// Codec Wire encoding of node-to-node RPC traffic. Servers
+// detect the codec of every connection, so a client picks
+// the codec without the server being configured for it
+type Codec string
+
+const (
+	// CodecGob net/rpc's default encoding
+	CodecGob Codec = "gob"
+	// CodecJSON JSON-RPC 1.0, readable and spoken by non-Go clients
+	CodecJSON Codec = "json"
+	// CodecBinary compact length-prefixed encoding, see binaryServerCodec
+	CodecBinary Codec = "binary"
+)
+
+var (
+	// ErrUnknownCodec if a codec name is not one of the Codec constants
+	ErrUnknownCodec = errors.New("unknown codec")
+)
+
+// binaryMagic opens connections using CodecBinary. gob streams
+// start with a message length, which is either below 0x80 or
+// at least 0xf8, and JSON with an ASCII character
+const binaryMagic = 0xb1
+
+// ParseCodec Returns the codec named s. Empty picks gob
+func ParseCodec(s string) (Codec, error) {
+	switch c := Codec(s); c {
+	case "":
+		return CodecGob, nil
+	case CodecGob, CodecJSON, CodecBinary:
+		return c, nil
+	}
+	return "", ErrUnknownCodec
+}
+
+// newClient Sets up an RPC client speaking codec over conn
+func newClient(conn net.Conn, codec Codec) (*rpc.Client, error) {
+	switch codec {
+	case CodecGob, "":
+		return rpc.NewClient(conn), nil
+	case CodecJSON:
+		return jsonrpc.NewClient(conn), nil
+	case CodecBinary:
+		if _, err := conn.Write([]byte{binaryMagic}); err != nil {
+			return nil, err
+		}
+		return rpc.NewClientWithCodec(newBinaryClientCodec(conn)), nil
+	}
+	return nil, ErrUnknownCodec
+}
+
+// bufferedConn Conn whose reads go through a reader that
+// already holds the bytes peeked at by serveConn
+type bufferedConn struct {
+	*bufio.Reader
+	net.Conn
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+// serveConn Serves conn with s in the codec the client speaks
+func serveConn(s *rpc.Server, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(2)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	bc := bufferedConn{Reader: r, Conn: conn}
+	switch {
+	case b[0] == binaryMagic:
+		r.Discard(1)
+		s.ServeCodec(newBinaryServerCodec(bc))
+	case isJSONStart(b[0]) && b[1] < 0x80:
+		// A gob message this short is followed by a negative type id,
+		// which never encodes to an ASCII byte
+		s.ServeCodec(jsonrpc.NewServerCodec(bc))
+	default:
+		s.ServeConn(bc)
+	}
+}
+
+func isJSONStart(b byte) bool {
+	return b == '{' || b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
@@ -0,0 +1,166 @@
+package netutils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/rpc"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+var codecs = []Codec{CodecGob, CodecJSON, CodecBinary}
+
+func TestCodecs(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	defer l.Close()
+
+	for _, codec := range codecs {
+		c, err := dialRPC(context.Background(), l.Addr().String(), PoolConfig{Handshake: NewHandshake(""), Codec: codec})
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		var reply comm.NodeID
+		err = c.Call(context.Background(), "NodeComm.FindSuccessor", &comm.Args{ID: "id", Timeout: time.Second}, &reply)
+		if err != nil || reply.ID != "id" || reply.IP != "s" {
+			t.Errorf("%s: unexpected reply %+v, %v", codec, reply, err)
+		}
+		err = c.Call(context.Background(), "NodeComm.GetRemote", &comm.KeyValue{Key: "k"}, &comm.KeyValue{})
+		if _, ok := err.(rpc.ServerError); !ok {
+			t.Errorf("%s: expected an rpc.ServerError, got %v", codec, err)
+		}
+		c.c.Close()
+	}
+}
+
+// A JSON-RPC request written by hand is answered like any other
+func TestCodecPlainJSONRPC(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	defer l.Close()
+	conn, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(`{"method":"NodeComm.GetSuccessor","params":[{}],"id":7}` + "\n"))
+	var resp struct {
+		ID     int
+		Result comm.NodeID
+		Error  interface{}
+	}
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 7 || resp.Result.IP != "s" || resp.Error != nil {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	type value struct {
+		Node    comm.Rnode
+		Nodes   []comm.NodeID
+		Keys    comm.Keys
+		Ptr     *string
+		Nil     *comm.Args
+		Neg     int
+		Flag    bool
+		Phi     float64
+		private int
+	}
+	s := "x"
+	in := value{
+		Node:  comm.Rnode{ID: util.Identifier{1, 2, 3}, IP: "a:1"},
+		Nodes: []comm.NodeID{{ID: "1", IP: "b"}, {ID: "2"}},
+		Keys:  comm.Keys{"k": "v", "": ""},
+		Ptr:   &s,
+		Neg:   -300,
+		Flag:  true,
+		Phi:   8.5,
+	}
+	b, err := appendFrame(nil, &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := frameReader{r: bufio.NewReader(&byteReader{b: b})}
+	var out value
+	if err := f.body(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	// Frames cut short are rejected
+	d := decoder{b: b[1 : len(b)-1]}
+	if err := d.value(reflect.ValueOf(&out).Elem()); err == nil {
+		t.Error("expected a truncated frame to fail")
+	}
+
+	// Forged counts are checked against the frame before allocating
+	type wide [1024]float64
+	forged := append(appendUvarint(nil, 4096), make([]byte, 4096)...)
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+	var huge []wide
+	d = decoder{b: forged}
+	if err := d.value(reflect.ValueOf(&huge).Elem()); err != errTruncated {
+		t.Errorf("expected a forged count to be rejected, got %v", err)
+	}
+	runtime.ReadMemStats(&ms)
+	if ms.TotalAlloc-before > 1<<20 {
+		t.Errorf("decoding a forged count allocated %d bytes", ms.TotalAlloc-before)
+	}
+}
+
+type byteReader struct {
+	b []byte
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, net.ErrClosed
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+func BenchmarkFindSuccessor(b *testing.B) {
+	l, err := ListenRPC("127.0.0.1:0", &stubNode{succ: "127.0.0.1:8011"}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	id := string(util.HashValueByte("key"))
+
+	for _, codec := range codecs {
+		b.Run(string(codec), func(b *testing.B) {
+			c, err := dialRPC(context.Background(), l.Addr().String(), PoolConfig{Handshake: NewHandshake(""), Codec: codec})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.c.Close()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				var reply comm.NodeID
+				for pb.Next() {
+					err := c.Call(context.Background(), "NodeComm.FindSuccessor",
+						&comm.Args{ID: id, Timeout: time.Second}, &reply)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
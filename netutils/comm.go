@@ -1,7 +1,10 @@
 package netutils
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,18 +18,28 @@ import (
 	"time"
 
 	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
 )
 
 // NodeRPC
 type NodeRPC struct {
 	sync.Mutex
-	host    string
-	c       *rpc.Client
+	host string
+	c    *rpc.Client
+	// Protocol version negotiated in the handshake
+	version int
 	timeout time.Duration
+	clock   util.Clock
 }
 
+const (
+	// DefaultRPCPort port used for node-to-node RPC unless configured
+	DefaultRPCPort = "8011"
+	// DefaultHTTPPort port of a node's key-value API unless configured
+	DefaultHTTPPort = "8030"
+)
+
 var (
-	PORT       = ":8011"
 	ErrTimeout = errors.New("RPC call timed out")
 )
 
@@ -38,71 +51,220 @@ func registerCommAPI(server *rpc.Server, comm comm.NodeComm) {
 	server.RegisterName("NodeComm", comm)
 }
 
-// ConnectRPC Instantiates a RPC connections
-func ConnectRPC(host string) (*NodeRPC, error) {
-	conn, err := net.Dial("tcp4", host+PORT)
+// WithPort returns addr with port appended if it does not carry one
+func WithPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// ConnectRPC Instantiates a RPC connection speaking codec
+// to a host:port address of a node on ring.
+// tlsConf secures the connection; nil connects in plaintext
+func ConnectRPC(host, ring string, codec Codec, tlsConf *tls.Config) (*NodeRPC, error) {
+	return dialRPC(context.Background(), host, PoolConfig{
+		Handshake: NewHandshake(ring),
+		Codec:     codec,
+		TLS:       tlsConf,
+	})
+}
+
+// dialRPC Connects to host as set up by cfg's DialTimeout, TLS,
+// Codec and Handshake, giving up once ctx is done
+func dialRPC(ctx context.Context, host string, cfg PoolConfig) (*NodeRPC, error) {
+	d := &net.Dialer{Timeout: cfg.DialTimeout}
+	var conn net.Conn
+	var err error
+	if cfg.TLS != nil {
+		td := tls.Dialer{NetDialer: d, Config: cfg.TLS}
+		conn, err = td.DialContext(ctx, "tcp4", host)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp4", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hs := cfg.Handshake
+	client, err := newClient(conn, cfg.Codec)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	client := rpc.NewClient(conn)
-	var r comm.NodeID
-	err = client.Call("NodeComm.Init", &comm.Args{ID: "init"}, &r)
+	// A peer that accepts connections but never answers must not
+	// hold up the handshake longer than a dial
+	timeout := cfg.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	var r comm.Handshake
+	call := client.Go("NodeComm.Init", &hs, &r, nil)
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		client.Close()
+		return nil, ctxErr(ctx)
+	}
 	if err != nil {
 		client.Close()
+		if _, ok := err.(rpc.ServerError); ok {
+			return nil, &IncompatibleError{Peer: host, Reason: "handshake rejected: " + err.Error()}
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, ErrTimeout
+		}
 		return nil, err
 	}
-	if r.ID != "init" {
+	conn.SetDeadline(time.Time{})
+	if r.ID != hs.ID {
 		client.Close()
 		return nil, fmt.Errorf("Init failed")
 	}
-	return &NodeRPC{c: client, host: host, timeout: time.Duration(time.Second * 2)}, nil
-}
-
-func (n *NodeRPC) reDial() error {
-	err := n.c.Close()
-	conn, err := net.Dial("tcp4", n.host+PORT)
-	n.c = rpc.NewClient(conn)
-	return err
+	if err := CheckHandshake(hs, r); err != nil {
+		client.Close()
+		err.Peer = host
+		return nil, err
+	}
+	// The binary codec has no field names to skip unknown fields by
+	if cfg.Codec == CodecBinary && r.Version != hs.Version {
+		client.Close()
+		return nil, &IncompatibleError{Peer: host, Reason: fmt.Sprintf(
+			"binary codec needs protocol version %d on both ends, peer speaks %d", hs.Version, r.Version)}
+	}
+	if r.Version == 0 {
+		r.Version = 1
+	}
+	return &NodeRPC{
+		c:       client,
+		host:    host,
+		version: r.Version,
+		timeout: time.Duration(time.Second * 2),
+		clock:   util.RealClock{},
+	}, nil
 }
 
 // SetupRPCServer Instantiates a RPC Server
 func SetupRPCServer(port string, api comm.NodeComm) (net.Listener, error) {
+	// the start means that we'll listen to
+	// all traffic; Not just localhost
+	return ListenRPC(":"+port, api, nil)
+}
+
+// ListenRPC Instantiates a RPC Server listening on addr. Connections
+// are secured with tlsConf unless it is nil. Closing the returned
+// listener also closes all accepted connections
+func ListenRPC(addr string, api comm.NodeComm, tlsConf *tls.Config) (net.Listener, error) {
 	s := rpc.NewServer()
 
 	registerCommAPI(s, api)
-	// the start means that we'll listen to
-	// all traffic; Not just localhost
-	l, err := net.Listen("tcp4", ":"+port)
+	l, err := net.Listen("tcp4", addr)
 	if err != nil {
 		return nil, err
 	}
+	if tlsConf != nil {
+		l = tls.NewListener(l, tlsConf)
+	}
+
+	rl := &rpcListener{Listener: l, conns: make(map[net.Conn]struct{})}
+	go rl.serve(s)
+	return rl, nil
+}
 
-	go s.Accept(l)
-	return l, nil
+// rpcListener Keeps track of accepted connections so that
+// a closed server stops answering on them as well
+type rpcListener struct {
+	net.Listener
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
 }
 
+func (l *rpcListener) serve(s *rpc.Server) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+
+		go func() {
+			serveConn(s, conn)
+			l.mu.Lock()
+			delete(l.conns, conn)
+			l.mu.Unlock()
+		}()
+	}
+}
+
+func (l *rpcListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// GetNodeIPs Retrieves the RPC addresses of all registered nodes
 func GetNodeIPs(address string) ([]string, error) {
+	return getNodeList(address, "/")
+}
+
+// GetNodeHTTPAddrs Retrieves the HTTP API addresses of all registered nodes
+func GetNodeHTTPAddrs(address string) ([]string, error) {
+	return getNodeList(address, "/http")
+}
+
+// GetNodeAddrs Retrieves the HTTP API address of each registered node,
+// keyed by its RPC address. Nodes without an HTTP API map to ""
+func GetNodeAddrs(address string) (map[string]string, error) {
+	addrs := make(map[string]string)
+	if err := getNameServer(address, "/addrs", &addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func getNodeList(address, path string) ([]string, error) {
 	var list []string
+	if err := getNameServer(address, path, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// getNameServer Decodes the JSON the nameserver at address serves on path into v
+func getNameServer(address, path string, v interface{}) error {
 	c := http.Client{Timeout: time.Duration(time.Second * 2)}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", address), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", address, path), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	resp, err := c.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("could not retrieve ipadresses from nameserver: %s", address)
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&list)
-	if err != nil {
-		return nil, err
+		return fmt.Errorf("could not retrieve ipadresses from nameserver: %s", address)
 	}
-	return list, nil
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
 func GetNodeIPsPython(address string) ([]string, error) {
@@ -131,16 +293,31 @@ func GetNodeIPsPython(address string) ([]string, error) {
 	return list, nil
 }
 
-func UnRegister(ip, nameserver string) {
-	http.PostForm(fmt.Sprintf("http://%s/unregister", nameserver),
-		url.Values{"ip": {ip}})
+// UnRegister Removes the node id at ip from the nameserver, proving
+// knowledge of the cluster secret like registering does
+func UnRegister(ip, nameserver string, id util.Identifier, secret string) {
+	form := url.Values{"ip": {ip}, "id": {hex.EncodeToString(id)}}
+	if secret != "" {
+		form.Set("proof", JoinProof(secret, id, ip))
+	}
+	http.PostForm(fmt.Sprintf("http://%s/unregister", nameserver), form)
 }
 
-func (n *NodeRPC) Call(method string, args interface{}, reply interface{}) error {
+// Call Issues method and waits for the per-call timeout, or until
+// ctx's deadline if that is sooner. A hung peer thus costs a lookup
+// one call's timeout rather than its whole budget
+func (n *NodeRPC) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	wait := n.timeout
+	if left := budget(ctx); left > 0 && left < wait {
+		wait = left
+	}
+	timeout := n.clock.After(wait)
 	call := n.c.Go(method, args, reply, nil)
 	select {
-	case <-time.After(n.timeout):
+	case <-timeout:
 		return ErrTimeout
+	case <-ctx.Done():
+		return ctxErr(ctx)
 	case call := <-call.Done:
 		if call.Error != nil {
 			return call.Error
@@ -0,0 +1,162 @@
+package netutils
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+const (
+	// DefaultPhiThreshold suspicion level at which a peer is considered failed
+	DefaultPhiThreshold = 8.0
+	// Number of inter-arrival samples kept per peer
+	phiWindowSize = 100
+	// Lower bound on the standard deviation; keeps phi from
+	// exploding on peers that answer with very regular intervals
+	phiMinStdDev = time.Millisecond * 100
+	// Slack added to the mean interval before a peer becomes suspicious
+	phiAcceptablePause = time.Millisecond * 500
+	// Interval assumed for a peer we've only heard from once
+	phiFirstHeartbeat = time.Second * 1
+)
+
+// arrivalWindow inter-arrival history of a single peer
+type arrivalWindow struct {
+	intervals []float64
+	sum       float64
+	sqsum     float64
+	last      time.Time
+	// set when a call to the peer failed after the last arrival
+	missed bool
+}
+
+// seed fills an empty window with samples around the first
+// heartbeat estimate so that early intervals have a baseline
+func (w *arrivalWindow) seed() {
+	first := phiFirstHeartbeat.Seconds()
+	w.add(first - first/4)
+	w.add(first + first/4)
+}
+
+func (w *arrivalWindow) add(interval float64) {
+	if len(w.intervals) >= phiWindowSize {
+		old := w.intervals[0]
+		w.intervals = w.intervals[1:]
+		w.sum -= old
+		w.sqsum -= old * old
+	}
+	w.intervals = append(w.intervals, interval)
+	w.sum += interval
+	w.sqsum += interval * interval
+}
+
+func (w *arrivalWindow) mean() float64 {
+	return w.sum / float64(len(w.intervals))
+}
+
+func (w *arrivalWindow) stdDev() float64 {
+	m := w.mean()
+	return math.Sqrt(math.Max(w.sqsum/float64(len(w.intervals))-m*m, 0))
+}
+
+// PhiDetector Phi accrual failure detector (Hayashibara et al.)
+// Responses from a peer are treated as heartbeats. The detector keeps
+// a sliding window of their inter-arrival times and, once a call to the
+// peer has failed, reports how unlikely the current silence is given
+// that history. A single slow response therefore yields a low phi, while
+// a peer that stays silent accrues suspicion over time.
+type PhiDetector struct {
+	sync.Mutex
+	peers     map[string]*arrivalWindow
+	threshold float64
+	clock     util.Clock
+}
+
+// NewPhiDetector creates a detector that suspects peers once their
+// phi reaches threshold. A threshold <= 0 disables suspicion entirely.
+// Arrival times are read from clock
+func NewPhiDetector(threshold float64, clock util.Clock) *PhiDetector {
+	return &PhiDetector{
+		peers:     make(map[string]*arrivalWindow),
+		threshold: threshold,
+		clock:     clock,
+	}
+}
+
+// Heartbeat records a response from peer
+func (d *PhiDetector) Heartbeat(peer string) {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.clock.Now()
+	w, ok := d.peers[peer]
+	if !ok {
+		w = &arrivalWindow{}
+		w.seed()
+		d.peers[peer] = w
+	} else {
+		w.add(now.Sub(w.last).Seconds())
+	}
+	w.last = now
+	w.missed = false
+}
+
+// Missed records a failed or timed out call to peer
+func (d *PhiDetector) Missed(peer string) {
+	d.Lock()
+	defer d.Unlock()
+
+	w, ok := d.peers[peer]
+	if !ok {
+		// Never heard from it; start the clock now so that
+		// continued silence accrues suspicion
+		w = &arrivalWindow{last: d.clock.Now()}
+		w.seed()
+		d.peers[peer] = w
+	}
+	w.missed = true
+}
+
+// Phi returns the current suspicion level of peer. Peers that have
+// answered the latest call made to them are never suspicious.
+func (d *PhiDetector) Phi(peer string) float64 {
+	d.Lock()
+	defer d.Unlock()
+
+	w, ok := d.peers[peer]
+	if !ok || !w.missed {
+		return 0
+	}
+	elapsed := d.clock.Now().Sub(w.last).Seconds()
+	mean := w.mean() + phiAcceptablePause.Seconds()
+	stdDev := math.Max(w.stdDev(), phiMinStdDev.Seconds())
+	return phi(elapsed, mean, stdDev)
+}
+
+// Suspect reports whether peer's phi has reached the threshold
+func (d *PhiDetector) Suspect(peer string) bool {
+	if d.threshold <= 0 {
+		return false
+	}
+	return d.Phi(peer) >= d.threshold
+}
+
+// Remove forgets all history of peer
+func (d *PhiDetector) Remove(peer string) {
+	d.Lock()
+	delete(d.peers, peer)
+	d.Unlock()
+}
+
+// phi computes -log10(1 - F(elapsed)) where F is the cumulative
+// normal distribution, using the logistic approximation from Akka
+func phi(elapsed, mean, stdDev float64) float64 {
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1.0 + e))
+	}
+	return -math.Log10(1.0 - 1.0/(1.0+e))
+}
@@ -0,0 +1,65 @@
+package netutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+func TestPhiUnknownPeer(t *testing.T) {
+	d := NewPhiDetector(DefaultPhiThreshold, util.RealClock{})
+	if phi := d.Phi("nobody"); phi != 0 {
+		t.Errorf("unknown peer should have phi 0, got %f", phi)
+	}
+	if d.Suspect("nobody") {
+		t.Errorf("unknown peer should not be suspected")
+	}
+}
+
+func TestPhiSingleMiss(t *testing.T) {
+	clock := util.NewFakeClock(time.Now())
+	d := NewPhiDetector(DefaultPhiThreshold, clock)
+	d.Heartbeat("a")
+	clock.Advance(time.Second)
+	d.Missed("a")
+	if d.Suspect("a") {
+		t.Errorf("a single missed call should not make a peer suspect (phi %f)", d.Phi("a"))
+	}
+	d.Heartbeat("a")
+	if phi := d.Phi("a"); phi != 0 {
+		t.Errorf("peer that answered should have phi 0, got %f", phi)
+	}
+}
+
+func TestPhiAccrues(t *testing.T) {
+	clock := util.NewFakeClock(time.Now())
+	d := NewPhiDetector(DefaultPhiThreshold, clock)
+	d.Heartbeat("a")
+	d.Missed("a")
+	clock.Advance(time.Second * 30)
+	if !d.Suspect("a") {
+		t.Errorf("silent peer should be suspected (phi %f)", d.Phi("a"))
+	}
+}
+
+func TestPhiDisabled(t *testing.T) {
+	clock := util.NewFakeClock(time.Now())
+	d := NewPhiDetector(0, clock)
+	d.Missed("a")
+	clock.Advance(time.Hour)
+	if d.Suspect("a") {
+		t.Errorf("threshold 0 should disable suspicion")
+	}
+}
+
+func TestPhiMonotonic(t *testing.T) {
+	prev := 0.0
+	for _, elapsed := range []float64{0.5, 1, 2, 4, 8} {
+		p := phi(elapsed, 1.5, 0.25)
+		if p < prev {
+			t.Errorf("phi should grow with elapsed time: phi(%f) = %f < %f", elapsed, p, prev)
+		}
+		prev = p
+	}
+}
@@ -0,0 +1,71 @@
+package netutils
+
+import (
+	"fmt"
+
+	"github.com/hoffa2/chord/comm"
+)
+
+const (
+	// ProtocolVersion version of the node-to-node protocol spoken by this build.
+	// Version 5 adds ClosestPreFingerArgs
+	ProtocolVersion = 5
+	// MinProtocolVersion oldest version this build still talks to
+	MinProtocolVersion = 1
+	// versionSuccessorList first version serving GetSuccessorList
+	versionSuccessorList = 2
+	// versionClosestPreFingerArgs first version serving ClosestPreFingerArgs;
+	// older peers only serve ClosestPreFinger, taking a bare identifier
+	versionClosestPreFingerArgs = 5
+	// DefaultHash and DefaultBits how identifiers are derived
+	DefaultHash = "sha1"
+	DefaultBits = 160
+)
+
+// IncompatibleError Peer speaks a protocol or belongs
+// to a ring this node cannot work with
+type IncompatibleError struct {
+	Peer   string
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	if e.Peer == "" {
+		return "incompatible peer: " + e.Reason
+	}
+	return fmt.Sprintf("incompatible peer %s: %s", e.Peer, e.Reason)
+}
+
+// NewHandshake Returns the handshake of this build for ring
+func NewHandshake(ring string) comm.Handshake {
+	return comm.Handshake{
+		ID:      "init",
+		Version: ProtocolVersion,
+		Hash:    DefaultHash,
+		Bits:    DefaultBits,
+		Ring:    ring,
+	}
+}
+
+// CheckHandshake Returns why a peer announcing peer cannot
+// work with a node announcing local, or nil if it can.
+// Peers predating the handshake only announce the ID; they
+// hash with SHA-1 into 160 bits and have no ring name
+func CheckHandshake(local, peer comm.Handshake) *IncompatibleError {
+	if peer.Version == 0 {
+		peer.Version = 1
+		peer.Hash = DefaultHash
+		peer.Bits = DefaultBits
+	}
+	switch {
+	case peer.Version < MinProtocolVersion:
+		return &IncompatibleError{Reason: fmt.Sprintf("protocol version %d, need at least %d",
+			peer.Version, MinProtocolVersion)}
+	case peer.Hash != local.Hash || peer.Bits != local.Bits:
+		return &IncompatibleError{Reason: fmt.Sprintf("identifiers are %s/%d bits, expected %s/%d bits",
+			peer.Hash, peer.Bits, local.Hash, local.Bits)}
+	case peer.Ring != local.Ring:
+		return &IncompatibleError{Reason: fmt.Sprintf("member of ring %q, expected %q", peer.Ring, local.Ring)}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+func TestCheckHandshake(t *testing.T) {
+	local := NewHandshake("")
+	legacy := comm.Handshake{ID: "init"}
+	other := NewHandshake("")
+	other.Bits = 64
+
+	if err := CheckHandshake(local, NewHandshake("")); err != nil {
+		t.Errorf("same build should be compatible, got %v", err)
+	}
+	if err := CheckHandshake(local, legacy); err != nil {
+		t.Errorf("peers predating the handshake should be compatible, got %v", err)
+	}
+	if err := CheckHandshake(NewHandshake("blue"), legacy); err == nil {
+		t.Error("peers predating the handshake are not on a named ring")
+	}
+	if err := CheckHandshake(local, other); err == nil {
+		t.Error("expected a bit width mismatch to be rejected")
+	}
+	if err := CheckHandshake(local, NewHandshake("blue")); err == nil {
+		t.Error("expected a ring name mismatch to be rejected")
+	}
+}
+
+// legacyNode answers Init the way nodes predating the handshake do
+type legacyNode struct{}
+
+func (legacyNode) Init(args *comm.Args, reply *comm.NodeID) error {
+	reply.ID = args.ID
+	return nil
+}
+
+func (legacyNode) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
+	reply.IP = "succ"
+	return nil
+}
+
+func (legacyNode) ClosestPreFinger(id *string, reply *comm.NodeID) error {
+	reply.ID = *id
+	return nil
+}
+
+// ringNode rejects peers that are not on its ring
+type ringNode struct {
+	stubNode
+	ring string
+}
+
+func (r *ringNode) Init(args *comm.Handshake, reply *comm.Handshake) error {
+	if err := CheckHandshake(NewHandshake(r.ring), *args); err != nil {
+		return err
+	}
+	*reply = NewHandshake(r.ring)
+	reply.ID = args.ID
+	return nil
+}
+
+func TestDialLegacyPeer(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterName("NodeComm", legacyNode{})
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Accept(l)
+	addr := l.Addr().String()
+
+	c, err := dialRPC(context.Background(), addr, PoolConfig{Handshake: NewHandshake(""), Codec: CodecGob})
+	if err != nil {
+		t.Fatalf("expected a legacy peer to be accepted, got %v", err)
+	}
+	c.c.Close()
+	if c.version != 1 {
+		t.Errorf("expected a legacy peer to speak version 1, got %d", c.version)
+	}
+
+	// Legacy peers lack GetSuccessorList, so only their successor is asked for
+	tr := NewRPCTransport(util.RealClock{}, PoolConfig{})
+	defer tr.Close()
+	r := NewRemote(tr, nil, NewPhiDetector(DefaultPhiThreshold, util.RealClock{}), RemoteConfig{})
+	list, err := r.GetSuccessorList(context.Background(), comm.Rnode{IP: addr})
+	if err != nil || len(list) != 1 || list[0].IP != "succ" {
+		t.Errorf("expected the successor of a legacy peer, got %v, %v", list, err)
+	}
+	id := util.StringToID(util.HashValue("key"))
+	if finger, err := r.ClosestPreFinger(context.Background(), comm.Rnode{IP: addr}, id); err != nil || !finger.ID.IsEqual(id) {
+		t.Errorf("expected a legacy peer to be sent a bare identifier, got %v, %v", finger, err)
+	}
+
+	_, err = dialRPC(context.Background(), addr, PoolConfig{Handshake: NewHandshake("blue"), Codec: CodecGob})
+	if _, ok := err.(*IncompatibleError); !ok {
+		t.Errorf("expected a legacy peer to be rejected on a named ring, got %v", err)
+	}
+}
+
+// oldNode announces the previous protocol version
+type oldNode struct {
+	stubNode
+}
+
+func (o *oldNode) Init(args *comm.Handshake, reply *comm.Handshake) error {
+	*reply = NewHandshake("")
+	reply.ID = args.ID
+	reply.Version = ProtocolVersion - 1
+	return nil
+}
+
+func TestDialBinaryVersionMismatch(t *testing.T) {
+	l, err := ListenRPC("127.0.0.1:0", &oldNode{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+
+	c, err := dialRPC(context.Background(), addr, PoolConfig{Handshake: NewHandshake(""), Codec: CodecGob})
+	if err != nil {
+		t.Fatalf("expected gob to tolerate an older peer, got %v", err)
+	}
+	c.c.Close()
+	_, err = dialRPC(context.Background(), addr, PoolConfig{Handshake: NewHandshake(""), Codec: CodecBinary})
+	if _, ok := err.(*IncompatibleError); !ok {
+		t.Errorf("expected the binary codec to reject an older peer, got %v", err)
+	}
+}
+
+func TestDialRejectedByPeer(t *testing.T) {
+	l, err := ListenRPC("127.0.0.1:0", &ringNode{ring: "blue"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = dialRPC(context.Background(), l.Addr().String(), PoolConfig{Handshake: NewHandshake("red"), Codec: CodecGob})
+	ierr, ok := err.(*IncompatibleError)
+	if !ok || !strings.Contains(ierr.Reason, "handshake rejected") {
+		t.Errorf("expected the peer to reject the handshake, got %v", err)
+	}
+}
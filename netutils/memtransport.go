@@ -0,0 +1,357 @@
+package netutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+var (
+	// ErrUnreachable if no node listens on the dialed address
+	ErrUnreachable = errors.New("connection refused")
+	// ErrAddrInUse if a node already listens on the address
+	ErrAddrInUse = errors.New("address already in use")
+)
+
+// MemNetwork An in-memory network connecting MemTransports.
+// Calls are dispatched directly to the listening NodeComm with
+// arguments and replies copied through gob, as on the wire.
+// Latency, message loss, partitions and reordering can be injected
+type MemNetwork struct {
+	mu        sync.Mutex
+	endpoints map[string]comm.NodeComm
+	rand      *rand.Rand
+	nextPort  int
+	// Delays calls
+	clock util.Clock
+
+	latency time.Duration
+	jitter  time.Duration
+	// Upper bound of the random extra delay used to reorder calls
+	reorder time.Duration
+	// Probability that a call is lost
+	dropRate float64
+	// How long a lost call takes to time out
+	timeout time.Duration
+	// Partition group of each address; missing addresses are in group 0
+	groups map[string]int
+}
+
+// NewMemNetwork creates an in-memory network. seed makes
+// the injected randomness reproducible
+func NewMemNetwork(seed int64) *MemNetwork {
+	return &MemNetwork{
+		endpoints: make(map[string]comm.NodeComm),
+		rand:      rand.New(rand.NewSource(seed)),
+		groups:    make(map[string]int),
+		nextPort:  1,
+		clock:     util.RealClock{},
+	}
+}
+
+// SetClock makes calls wait for latency and timeouts on clock
+func (m *MemNetwork) SetClock(clock util.Clock) {
+	m.mu.Lock()
+	m.clock = clock
+	m.mu.Unlock()
+}
+
+// Transport creates a transport attached to the network
+func (m *MemNetwork) Transport() *MemTransport {
+	return &MemTransport{net: m}
+}
+
+// SetLatency delays every call by latency plus up to jitter
+func (m *MemNetwork) SetLatency(latency, jitter time.Duration) {
+	m.mu.Lock()
+	m.latency, m.jitter = latency, jitter
+	m.mu.Unlock()
+}
+
+// SetReorder delays each call by a random duration below window,
+// so concurrent calls are delivered out of order
+func (m *MemNetwork) SetReorder(window time.Duration) {
+	m.mu.Lock()
+	m.reorder = window
+	m.mu.Unlock()
+}
+
+// SetDropRate makes calls get lost with probability p.
+// Lost calls fail with ErrTimeout after timeout
+func (m *MemNetwork) SetDropRate(p float64, timeout time.Duration) {
+	m.mu.Lock()
+	m.dropRate, m.timeout = p, timeout
+	m.mu.Unlock()
+}
+
+// Partition splits the network so that only addresses within the same
+// group can reach each other. Addresses not listed together form one more
+// group, so they still reach each other, and Heal lists none
+func (m *MemNetwork) Partition(groups ...[]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.groups = make(map[string]int)
+	for i, g := range groups {
+		for _, addr := range g {
+			m.groups[addr] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (m *MemNetwork) Heal() {
+	m.Partition()
+}
+
+func (m *MemNetwork) listen(addr string, api comm.NodeComm) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Port 0 picks a free port, as with tcp
+	if host, port, err := net.SplitHostPort(addr); err == nil && port == "0" {
+		for {
+			addr = net.JoinHostPort(host, strconv.Itoa(m.nextPort))
+			m.nextPort++
+			if _, ok := m.endpoints[addr]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := m.endpoints[addr]; ok {
+		return "", ErrAddrInUse
+	}
+	m.endpoints[addr] = api
+	return addr, nil
+}
+
+func (m *MemNetwork) after(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	clock := m.clock
+	m.mu.Unlock()
+	return clock.After(d)
+}
+
+func (m *MemNetwork) unlisten(addr string) {
+	m.mu.Lock()
+	delete(m.endpoints, addr)
+	m.mu.Unlock()
+}
+
+// route decides the fate of a call from one address to another.
+// Returns the receiving endpoint and the delay before the outcome is
+// known. Lost or partitioned calls fail with ErrTimeout
+func (m *MemNetwork) route(from, to string) (comm.NodeComm, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.groups[from] != m.groups[to] ||
+		(m.dropRate > 0 && m.rand.Float64() < m.dropRate) {
+		return nil, m.timeout, ErrTimeout
+	}
+	api, ok := m.endpoints[to]
+	if !ok {
+		return nil, 0, ErrUnreachable
+	}
+
+	delay := m.latency
+	if m.jitter > 0 {
+		delay += time.Duration(m.rand.Int63n(int64(m.jitter)))
+	}
+	if m.reorder > 0 {
+		delay += time.Duration(m.rand.Int63n(int64(m.reorder)))
+	}
+	return api, delay, nil
+}
+
+// MemTransport Transport over a MemNetwork
+type MemTransport struct {
+	net *MemNetwork
+	mu  sync.Mutex
+	// Address of this transport's endpoint, used for partitioning
+	local  string
+	closed bool
+}
+
+type memEndpoint struct {
+	t    *MemTransport
+	addr string
+}
+
+func (e memEndpoint) Addr() string {
+	return e.addr
+}
+
+func (e memEndpoint) Close() error {
+	e.t.net.unlisten(e.addr)
+	e.t.mu.Lock()
+	e.t.closed = true
+	e.t.mu.Unlock()
+	return nil
+}
+
+// Listen Attaches api to the network at addr
+func (t *MemTransport) Listen(addr string, api comm.NodeComm) (Listener, error) {
+	addr, err := t.net.listen(addr, api)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.local = addr
+	t.closed = false
+	t.mu.Unlock()
+	return memEndpoint{t: t, addr: addr}, nil
+}
+
+// Call Delivers method to the node at addr
+func (t *MemTransport) Call(ctx context.Context, addr, method string, args interface{}, reply interface{}) error {
+	t.mu.Lock()
+	local, closed := t.local, t.closed
+	t.mu.Unlock()
+	if closed {
+		return rpc.ErrShutdown
+	}
+
+	api, delay, err := t.net.route(local, addr)
+	if delay > 0 {
+		select {
+		case <-t.net.after(delay):
+		case <-ctx.Done():
+			return ctxErr(ctx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctxErr(ctx)
+	}
+	return dispatch(api, method, args, reply)
+}
+
+// Ping Checks whether addr is reachable
+func (t *MemTransport) Ping(ctx context.Context, addr string) error {
+	t.mu.Lock()
+	local := t.local
+	t.mu.Unlock()
+	_, _, err := t.net.route(local, addr)
+	return err
+}
+
+// Version Every node on the network runs this build
+func (t *MemTransport) Version(ctx context.Context, addr string) (int, error) {
+	return ProtocolVersion, nil
+}
+
+// Evict Nothing is cached
+func (t *MemTransport) Evict(addr string) {}
+
+// Close Nothing is cached
+func (t *MemTransport) Close() error {
+	return nil
+}
+
+// dispatch invokes method on api the way net/rpc would. Arguments
+// and replies are copied through gob so that no memory is shared
+func dispatch(api comm.NodeComm, method string, args interface{}, reply interface{}) error {
+	name := strings.TrimPrefix(method, "NodeComm.")
+	m := reflect.ValueOf(api).MethodByName(name)
+	if !m.IsValid() {
+		return fmt.Errorf("rpc: can't find method %s", method)
+	}
+	argv := reflect.New(m.Type().In(0).Elem())
+	replyv := reflect.New(m.Type().In(1).Elem())
+	err := copyValue(args, argv.Interface())
+	if err != nil {
+		return err
+	}
+
+	out := m.Call([]reflect.Value{argv, replyv})
+	if errv := out[0].Interface(); errv != nil {
+		return rpc.ServerError(errv.(error).Error())
+	}
+	if reply == nil {
+		return nil
+	}
+	return copyValue(replyv.Interface(), reply)
+}
+
+// copyValue copies from into the pointer to. Values of the same type are
+// copied field by field, which is much cheaper than gob; anything else
+// goes through gob, which converts between compatible types
+func copyValue(from, to interface{}) error {
+	src, dst := reflect.ValueOf(from), reflect.ValueOf(to)
+	if src.Type() != dst.Type() || src.IsNil() {
+		return gobCopy(from, to)
+	}
+	deepCopy(dst.Elem(), src.Elem())
+	return nil
+}
+
+// deepCopy copies src into dst the way a round trip over the wire would:
+// no memory is shared and unexported fields are left at their zero value
+func deepCopy(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		v := reflect.New(src.Type().Elem())
+		deepCopy(v.Elem(), src.Elem())
+		dst.Set(v)
+	case reflect.Struct:
+		t := src.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				deepCopy(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		v := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(v.Index(i), src.Index(i))
+		}
+		dst.Set(v)
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		v := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			val := reflect.New(src.Type().Elem()).Elem()
+			deepCopy(val, iter.Value())
+			v.SetMapIndex(iter.Key(), val)
+		}
+		dst.Set(v)
+	default:
+		dst.Set(src)
+	}
+}
+
+func gobCopy(from, to interface{}) error {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(from)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(&b).Decode(to)
+}
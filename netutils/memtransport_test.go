@@ -0,0 +1,166 @@
+package netutils
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+// stubNode answers Init, FindSuccessor, GetSuccessor and GetRemote;
+// other methods are not used
+type stubNode struct {
+	comm.NodeComm
+	succ string
+}
+
+func (s *stubNode) Init(args *comm.Handshake, reply *comm.Handshake) error {
+	*reply = NewHandshake("")
+	reply.ID = args.ID
+	return nil
+}
+
+func (s *stubNode) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
+	reply.IP = s.succ
+	return nil
+}
+
+func (s *stubNode) FindSuccessor(args *comm.Args, reply *comm.NodeID) error {
+	reply.ID = args.ID
+	reply.IP = s.succ
+	return nil
+}
+
+func (s *stubNode) GetRemote(args *comm.KeyValue, reply *comm.KeyValue) error {
+	return errors.New("No value on key")
+}
+
+func listenStub(t *testing.T, m *MemNetwork, succ string) (*MemTransport, string) {
+	tr := m.Transport()
+	l, err := tr.Listen("127.0.0.1:0", &stubNode{succ: succ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, l.Addr()
+}
+
+func TestMemTransportCall(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemNetwork(1)
+	a, _ := listenStub(t, m, "a-succ")
+	_, baddr := listenStub(t, m, "b-succ")
+
+	var reply comm.NodeID
+	err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.IP != "b-succ" {
+		t.Errorf("expected b-succ, got %s", reply.IP)
+	}
+
+	err = a.Call(ctx, baddr, "NodeComm.GetRemote", &comm.KeyValue{Key: "k"}, &comm.KeyValue{})
+	if _, ok := err.(rpc.ServerError); !ok {
+		t.Errorf("handler errors should be rpc.ServerError, got %v", err)
+	}
+
+	if err := a.Call(ctx, "127.0.0.1:999", "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != ErrUnreachable {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+}
+
+func TestMemTransportPartition(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemNetwork(1)
+	a, aaddr := listenStub(t, m, "")
+	b, baddr := listenStub(t, m, "")
+
+	m.Partition([]string{aaddr})
+	var reply comm.NodeID
+	if err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout across partition, got %v", err)
+	}
+	if err := b.Ping(ctx, aaddr); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout across partition, got %v", err)
+	}
+
+	m.Heal()
+	if err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != nil {
+		t.Errorf("expected call to succeed after heal, got %v", err)
+	}
+}
+
+func TestMemTransportDrop(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemNetwork(1)
+	a, _ := listenStub(t, m, "")
+	_, baddr := listenStub(t, m, "")
+
+	m.SetDropRate(1, 0)
+	var reply comm.NodeID
+	if err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != ErrTimeout {
+		t.Errorf("expected dropped call to time out, got %v", err)
+	}
+}
+
+func TestMemTransportClosed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemNetwork(1)
+	a := m.Transport()
+	la, err := a.Listen("127.0.0.1:0", &stubNode{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, baddr := listenStub(t, m, "")
+
+	la.Close()
+	if err := b.Ping(ctx, la.Addr()); err != ErrUnreachable {
+		t.Errorf("expected closed endpoint to be unreachable, got %v", err)
+	}
+	var reply comm.NodeID
+	if err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != rpc.ErrShutdown {
+		t.Errorf("closed transport should not send, got %v", err)
+	}
+}
+
+func TestMemTransportDeadline(t *testing.T) {
+	m := NewMemNetwork(1)
+	a, _ := listenStub(t, m, "")
+	_, baddr := listenStub(t, m, "")
+	m.SetLatency(time.Second, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	var reply comm.NodeID
+	if err := a.Call(ctx, baddr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout once the deadline passed, got %v", err)
+	}
+
+	// A cancelled caller must not count against the peer
+	failed := false
+	r := NewRemote(a, func(rn *comm.Rnode, err error) { failed = true },
+		NewPhiDetector(DefaultPhiThreshold, util.RealClock{}), RemoteConfig{})
+	ctx, cancel = context.WithCancel(context.Background())
+	go cancel()
+	_, err := r.GetSuccessor(ctx, comm.Rnode{IP: baddr})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if failed {
+		t.Error("cancelled call was reported as a failure")
+	}
+
+	// Nor must a caller whose own deadline passed
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := r.GetSuccessor(ctx, comm.Rnode{IP: baddr}); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if failed || r.Phi(comm.Rnode{IP: baddr}) != 0 || r.BreakerStats().Opened != 0 {
+		t.Error("call that ran out of the caller's time was blamed on the peer")
+	}
+}
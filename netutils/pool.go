@@ -0,0 +1,388 @@
+package netutils
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+// PoolConfig Tunes a connection pool. Zero values pick the defaults
+type PoolConfig struct {
+	// ConnsPerPeer connections kept to each peer; calls use them in turn
+	ConnsPerPeer int
+	// IdleTimeout connections unused for this long are closed
+	IdleTimeout time.Duration
+	// DialTimeout bounds a single dial
+	DialTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait before redialing a peer
+	// whose last dial failed. The wait doubles with every failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Handshake sent on every new connection. Defaults to the unnamed ring
+	Handshake comm.Handshake
+	// Codec spoken on new connections. Defaults to gob
+	Codec Codec
+	// TLS secures new connections; nil dials in plaintext
+	TLS *tls.Config
+}
+
+const (
+	defaultIdleTimeout = time.Minute
+	defaultDialTimeout = time.Second * 1
+	defaultMinBackoff  = time.Millisecond * 100
+	defaultMaxBackoff  = time.Second * 10
+)
+
+func (c *PoolConfig) setDefaults() {
+	if c.ConnsPerPeer <= 0 {
+		c.ConnsPerPeer = 1
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = defaultIdleTimeout
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = defaultMinBackoff
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = defaultMaxBackoff
+	}
+	if c.Codec == "" {
+		c.Codec = CodecGob
+	}
+	if c.Handshake.Version == 0 {
+		c.Handshake = NewHandshake("")
+	}
+}
+
+// PoolStats Counters of a connection pool
+type PoolStats struct {
+	// Peers and Conns currently pooled
+	Peers int
+	Conns int
+	// Dials attempted and failed
+	Dials      int
+	DialErrors int
+	// Backoffs calls failed fast because their peer was backing off
+	Backoffs int
+	// Broken connections dropped after ErrShutdown or EOF
+	Broken int
+	// Retries calls reissued on a fresh connection
+	Retries int
+	// Idle connections closed for being unused
+	Idle int
+	// Evicted connections closed on request
+	Evicted int
+}
+
+// pooledConn A connection and when it was last used
+type pooledConn struct {
+	*NodeRPC
+	used time.Time
+}
+
+// peerConns Connections to one peer and its redial backoff
+type peerConns struct {
+	sync.Mutex
+	conns []*pooledConn
+	next  int
+	// Consecutive failed dials, the last error and when to try again
+	failures int
+	lastErr  error
+	retryAt  time.Time
+	// Closed once the dial in progress, if any, is done
+	dialing chan struct{}
+}
+
+// Pool Keeps RPC connections to peers. Broken connections are
+// dropped and redialed, peers that refuse dials are backed off,
+// and connections left idle are closed
+type Pool struct {
+	mu    sync.Mutex
+	cfg   PoolConfig
+	clock util.Clock
+	peers map[string]*peerConns
+	stats PoolStats
+	// Time of the next sweep for idle connections
+	nextPrune time.Time
+}
+
+// NewPool creates an empty pool. Idle times and backoffs are measured on clock
+func NewPool(cfg PoolConfig, clock util.Clock) *Pool {
+	cfg.setDefaults()
+	return &Pool{
+		cfg:   cfg,
+		clock: clock,
+		peers: make(map[string]*peerConns),
+	}
+}
+
+// broken reports whether err means the connection itself is gone
+func broken(err error) bool {
+	return err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// Call issues method on addr. A call that finds its connection shut
+// down was never sent, so it is retried once on a fresh connection;
+// this is how a restarted peer is reached again
+func (p *Pool) Call(ctx context.Context, addr, method string, args interface{}, reply interface{}) error {
+	c, err := p.get(ctx, addr)
+	if err != nil {
+		return err
+	}
+	err = c.Call(ctx, method, args, reply)
+	if !broken(err) {
+		return err
+	}
+	p.drop(addr, c)
+	if err != rpc.ErrShutdown {
+		return err
+	}
+
+	p.count(func(s *PoolStats) { s.Retries++ })
+	c, err = p.get(ctx, addr)
+	if err != nil {
+		return err
+	}
+	err = c.Call(ctx, method, args, reply)
+	if broken(err) {
+		p.drop(addr, c)
+	}
+	return err
+}
+
+// Version returns the protocol version negotiated with addr,
+// dialing it if no connection is pooled
+func (p *Pool) Version(ctx context.Context, addr string) (int, error) {
+	c, err := p.get(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	return c.version, nil
+}
+
+func (p *Pool) count(f func(s *PoolStats)) {
+	p.mu.Lock()
+	f(&p.stats)
+	p.mu.Unlock()
+}
+
+// peer returns the entry of addr, creating it if needed
+func (p *Pool) peer(addr string) *peerConns {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.peers[addr]
+	if !ok {
+		pc = &peerConns{}
+		p.peers[addr] = pc
+	}
+	return pc
+}
+
+// get returns a connection to addr, dialing one if fewer than
+// ConnsPerPeer are open. Dials run without pc locked, so a peer that
+// never answers holds up neither calls over its pooled connections
+// nor, beyond their own deadline, callers waiting for the dial
+func (p *Pool) get(ctx context.Context, addr string) (*NodeRPC, error) {
+	p.prune()
+	pc := p.peer(addr)
+	for {
+		pc.Lock()
+		now := p.clock.Now()
+		if len(pc.conns) < p.cfg.ConnsPerPeer && pc.dialing == nil {
+			if pc.failures > 0 && now.Before(pc.retryAt) {
+				if len(pc.conns) == 0 {
+					err := pc.lastErr
+					pc.Unlock()
+					p.count(func(s *PoolStats) { s.Backoffs++ })
+					return nil, err
+				}
+			} else {
+				done := make(chan struct{})
+				pc.dialing = done
+				pc.Unlock()
+				c, err := p.dial(ctx, addr)
+				pc.Lock()
+				pc.dialing = nil
+				close(done)
+				// A caller giving up says nothing about the peer
+				if err == nil || ctx.Err() == nil {
+					p.dialed(pc, err)
+				}
+				if err == nil {
+					pc.conns = append(pc.conns, &pooledConn{NodeRPC: c, used: now})
+				} else if len(pc.conns) == 0 {
+					pc.Unlock()
+					return nil, err
+				}
+			}
+		}
+		if len(pc.conns) == 0 {
+			// Wait for the dial another call has started
+			done := pc.dialing
+			pc.Unlock()
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return nil, ctxErr(ctx)
+			}
+		}
+
+		c := pc.conns[pc.next%len(pc.conns)]
+		pc.next++
+		c.used = now
+		pc.Unlock()
+		return c.NodeRPC, nil
+	}
+}
+
+// dial connects to addr
+func (p *Pool) dial(ctx context.Context, addr string) (*NodeRPC, error) {
+	p.count(func(s *PoolStats) { s.Dials++ })
+	c, err := dialRPC(ctx, addr, p.cfg)
+	if err != nil {
+		p.count(func(s *PoolStats) { s.DialErrors++ })
+		return nil, err
+	}
+	c.clock = p.clock
+	return c, nil
+}
+
+// dialed updates pc's backoff after a dial that returned err.
+// Expects pc to be locked
+func (p *Pool) dialed(pc *peerConns, err error) {
+	if err == nil {
+		pc.failures = 0
+		pc.lastErr = nil
+		return
+	}
+	pc.failures++
+	pc.lastErr = err
+	wait := p.cfg.MinBackoff << uint(pc.failures-1)
+	if wait > p.cfg.MaxBackoff || wait <= 0 {
+		wait = p.cfg.MaxBackoff
+	}
+	pc.retryAt = p.clock.Now().Add(wait)
+}
+
+// drop closes c and removes it from addr's connections
+func (p *Pool) drop(addr string, c *NodeRPC) {
+	pc := p.peer(addr)
+	pc.Lock()
+	for i, pooled := range pc.conns {
+		if pooled.NodeRPC == c {
+			pc.conns = append(pc.conns[:i], pc.conns[i+1:]...)
+			p.count(func(s *PoolStats) { s.Broken++ })
+			break
+		}
+	}
+	pc.Unlock()
+	c.c.Close()
+}
+
+// Evict closes all connections to addr. Its backoff is kept
+func (p *Pool) Evict(addr string) {
+	p.mu.Lock()
+	pc, ok := p.peers[addr]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	pc.Lock()
+	conns := pc.conns
+	pc.conns = nil
+	pc.Unlock()
+	for _, c := range conns {
+		c.c.Close()
+	}
+	p.count(func(s *PoolStats) { s.Evicted += len(conns) })
+}
+
+// prune closes connections that have been idle for IdleTimeout.
+// Sweeps at most once per IdleTimeout
+func (p *Pool) prune() {
+	now := p.clock.Now()
+	p.mu.Lock()
+	if now.Before(p.nextPrune) {
+		p.mu.Unlock()
+		return
+	}
+	p.nextPrune = now.Add(p.cfg.IdleTimeout)
+	peers := make(map[string]*peerConns, len(p.peers))
+	for addr, pc := range p.peers {
+		peers[addr] = pc
+	}
+	p.mu.Unlock()
+
+	for addr, pc := range peers {
+		pc.Lock()
+		var keep []*pooledConn
+		idle := 0
+		for _, c := range pc.conns {
+			if now.Sub(c.used) >= p.cfg.IdleTimeout {
+				c.c.Close()
+				idle++
+			} else {
+				keep = append(keep, c)
+			}
+		}
+		pc.conns = keep
+		empty := len(keep) == 0 && pc.failures == 0 && pc.dialing == nil
+		pc.Unlock()
+
+		p.mu.Lock()
+		p.stats.Idle += idle
+		// Forget peers with nothing left to remember
+		if empty && p.peers[addr] == pc {
+			delete(p.peers, addr)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Stats Returns a snapshot of the pool's counters
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	s := p.stats
+	peers := make([]*peerConns, 0, len(p.peers))
+	for _, pc := range p.peers {
+		peers = append(peers, pc)
+	}
+	p.mu.Unlock()
+
+	for _, pc := range peers {
+		pc.Lock()
+		if len(pc.conns) > 0 {
+			s.Peers++
+			s.Conns += len(pc.conns)
+		}
+		pc.Unlock()
+	}
+	return s
+}
+
+// Close closes every pooled connection
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	addrs := make([]string, 0, len(p.peers))
+	for addr := range p.peers {
+		addrs = append(addrs, addr)
+	}
+	p.mu.Unlock()
+	for _, addr := range addrs {
+		p.Evict(addr)
+	}
+	p.mu.Lock()
+	p.peers = make(map[string]*peerConns)
+	p.mu.Unlock()
+	return nil
+}
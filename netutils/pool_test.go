@@ -0,0 +1,206 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+func listenRPCStub(t *testing.T, addr string) net.Listener {
+	l, err := ListenRPC(addr, &stubNode{succ: "s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func poolCall(p *Pool, addr string) error {
+	var reply comm.NodeID
+	return p.Call(context.Background(), addr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply)
+}
+
+// hungNode never answers GetSuccessor
+type hungNode struct {
+	stubNode
+}
+
+func (h *hungNode) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
+	select {}
+}
+
+func TestCallTimesOutBeforeDeadline(t *testing.T) {
+	l, err := ListenRPC("127.0.0.1:0", &hungNode{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := dialRPC(context.Background(), l.Addr().String(), PoolConfig{Handshake: NewHandshake(""), Codec: CodecGob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.c.Close()
+	c.timeout = time.Millisecond * 100
+
+	// The caller's longer deadline does not stretch the per-call timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	began := time.Now()
+	var reply comm.NodeID
+	err = c.Call(ctx, "NodeComm.GetSuccessor", &comm.Empty{}, &reply)
+	if err != ErrTimeout || time.Since(began) > time.Second || ctx.Err() != nil {
+		t.Errorf("expected the call to time out after 100ms, got %v after %s", err, time.Since(began))
+	}
+}
+
+func TestPoolRedialsRestartedPeer(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	addr := l.Addr().String()
+	p := NewPool(PoolConfig{}, util.RealClock{})
+	defer p.Close()
+
+	if err := poolCall(p, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart the peer on the same address; the pooled connection breaks
+	l.Close()
+	time.Sleep(time.Millisecond * 50)
+	l = listenRPCStub(t, addr)
+	defer l.Close()
+
+	if err := poolCall(p, addr); err != nil {
+		t.Fatalf("call to restarted peer failed: %v", err)
+	}
+	s := p.Stats()
+	if s.Broken != 1 || s.Retries != 1 || s.Dials != 2 {
+		t.Errorf("expected one broken connection to be redialed, got %+v", s)
+	}
+}
+
+func TestPoolBacksOff(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	clock := util.NewFakeClock(time.Now())
+	p := NewPool(PoolConfig{MinBackoff: time.Second, MaxBackoff: time.Second * 2}, clock)
+	defer p.Close()
+
+	if err := poolCall(p, addr); err == nil {
+		t.Fatal("expected dial to a closed port to fail")
+	}
+	if err := poolCall(p, addr); err == nil {
+		t.Fatal("expected call during backoff to fail")
+	}
+	if s := p.Stats(); s.Dials != 1 || s.Backoffs != 1 {
+		t.Errorf("expected a single dial while backing off, got %+v", s)
+	}
+
+	l = listenRPCStub(t, addr)
+	defer l.Close()
+	clock.Advance(time.Second)
+	if err := poolCall(p, addr); err != nil {
+		t.Fatalf("expected redial after backoff, got %v", err)
+	}
+}
+
+func TestPoolConnsPerPeerAndIdle(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	defer l.Close()
+	addr := l.Addr().String()
+
+	clock := util.NewFakeClock(time.Now())
+	p := NewPool(PoolConfig{ConnsPerPeer: 3, IdleTimeout: time.Minute}, clock)
+	defer p.Close()
+
+	for i := 0; i < 6; i++ {
+		if err := poolCall(p, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := p.Stats(); s.Conns != 3 || s.Dials != 3 || s.Peers != 1 {
+		t.Errorf("expected 3 connections to one peer, got %+v", s)
+	}
+
+	clock.Advance(time.Minute)
+	p.prune()
+	if s := p.Stats(); s.Conns != 0 || s.Idle != 3 {
+		t.Errorf("expected idle connections to be closed, got %+v", s)
+	}
+}
+
+func TestPoolEvict(t *testing.T) {
+	l := listenRPCStub(t, "127.0.0.1:0")
+	defer l.Close()
+	addr := l.Addr().String()
+	p := NewPool(PoolConfig{}, util.RealClock{})
+	defer p.Close()
+
+	if err := poolCall(p, addr); err != nil {
+		t.Fatal(err)
+	}
+	p.Evict(addr)
+	if s := p.Stats(); s.Conns != 0 || s.Evicted != 1 {
+		t.Errorf("expected the connection to be evicted, got %+v", s)
+	}
+	if err := poolCall(p, addr); err != nil {
+		t.Fatalf("expected a fresh dial after eviction, got %v", err)
+	}
+}
+
+// listenSilent accepts connections and never answers on them
+func listenSilent(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l
+}
+
+func TestPoolSilentPeer(t *testing.T) {
+	l := listenSilent(t)
+	defer l.Close()
+	addr := l.Addr().String()
+
+	// The handshake gives up with the caller or after the dial timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	began := time.Now()
+	if _, err := dialRPC(ctx, addr, PoolConfig{Handshake: NewHandshake(""), DialTimeout: time.Minute}); err == nil || time.Since(began) > time.Second {
+		t.Errorf("expected the handshake to give up with the caller, got %v after %s", err, time.Since(began))
+	}
+	began = time.Now()
+	if _, err := dialRPC(context.Background(), addr, PoolConfig{Handshake: NewHandshake(""), DialTimeout: time.Millisecond * 100}); err != ErrTimeout || time.Since(began) > time.Second {
+		t.Errorf("expected the handshake to time out, got %v after %s", err, time.Since(began))
+	}
+
+	// Callers waiting for another's dial give up on their own deadline
+	p := NewPool(PoolConfig{DialTimeout: time.Second * 2}, util.RealClock{})
+	defer p.Close()
+	go poolCall(p, addr)
+	time.Sleep(time.Millisecond * 50)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	began = time.Now()
+	var reply comm.NodeID
+	if err := p.Call(ctx, addr, "NodeComm.GetSuccessor", &comm.Empty{}, &reply); err != ErrTimeout || time.Since(began) > time.Second {
+		t.Errorf("expected the waiting call to time out, got %v after %s", err, time.Since(began))
+	}
+}
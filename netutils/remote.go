@@ -1,100 +1,245 @@
 package netutils
 
 import (
-	"net"
-	"sync"
+	"context"
+	"log"
+	"reflect"
 	"time"
 
 	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/tracing"
 	"github.com/hoffa2/chord/util"
 )
 
-type failhandler func(rn *comm.Rnode)
+// failhandler is invoked whenever a call to a peer fails
+// at the connection level or times out
+type failhandler func(rn *comm.Rnode, err error)
+
+// RemoteConfig Tunes how Remote calls peers
+type RemoteConfig struct {
+	Retry   RetryPolicy
+	Breaker BreakerConfig
+	// Clock times retries and breaker cooldowns. Defaults to the wall clock
+	Clock util.Clock
+	// Seed seeds the retry jitter
+	Seed int64
+	// Log receives circuit breaker transitions; nil discards them
+	Log *log.Logger
+	// Secret cluster secret proving membership when announcing nodes
+	Secret string
+	// Observe is told the outcome of every call, e.g. to keep metrics
+	Observe func(method, peer string, took time.Duration, err error)
+	// Tracer records a span for every call made for a traced request
+	// and sends its context along; nil disables tracing
+	Tracer *tracing.Tracer
+}
 
 // Wraps the RPC communication
 type Remote struct {
-	conns map[string]*NodeRPC
-	sync.Mutex
-	fail    failhandler
-	timeout time.Duration
+	t    Transport
+	fail failhandler
+	// Suspicion of peers based on their response history
+	detector *PhiDetector
+	retry    *retrier
+	breakers *breakers
+	secret   string
+	clock    util.Clock
+	observe  func(method, peer string, took time.Duration, err error)
+	tracer   *tracing.Tracer
 }
 
-func NewRemote(f failhandler) *Remote {
+func NewRemote(t Transport, f failhandler, d *PhiDetector, cfg RemoteConfig) *Remote {
+	if cfg.Clock == nil {
+		cfg.Clock = util.RealClock{}
+	}
 	return &Remote{
-		conns:   make(map[string]*NodeRPC),
-		fail:    f,
-		timeout: time.Duration(time.Second * 1),
+		t:        t,
+		fail:     f,
+		detector: d,
+		retry:    newRetrier(cfg.Retry, cfg.Clock, cfg.Seed),
+		breakers: newBreakers(cfg.Breaker, cfg.Clock, cfg.Log),
+		secret:   cfg.Secret,
+		clock:    cfg.Clock,
+		observe:  cfg.Observe,
+		tracer:   cfg.Tracer,
 	}
 }
 
-func (r *Remote) get(rn comm.Rnode) (*NodeRPC, error) {
-	r.Lock()
-	defer r.Unlock()
-	val, ok := r.conns[rn.IP]
-	if !ok {
-		conn, err := ConnectRPC(rn.IP)
-		if err != nil {
-			return nil, err
+// call issues method on rn once and feeds the outcome to the
+// failure detector and rn's circuit breaker
+func (r *Remote) call(ctx context.Context, rn comm.Rnode, method string, args interface{}, reply interface{}) error {
+	// A caller that has already given up says nothing about rn
+	if ctx.Err() != nil {
+		return ctxErr(ctx)
+	}
+	if !r.breakers.allow(rn.IP) {
+		return ErrCircuitOpen
+	}
+	span := r.tracer.StartCall(ctx, method, rn.IP)
+	if t, ok := args.(comm.Traced); ok && span != nil {
+		t.SetTrace(span.Traceparent())
+	}
+	began := r.clock.Now()
+	// A response arriving after the call gave up is still decoded into
+	// the value passed; only a completed attempt's reply reaches the caller
+	attempt := fresh(reply)
+	err := r.t.Call(ctx, rn.IP, method, args, attempt)
+	span.End(err)
+	if r.observe != nil {
+		r.observe(method, rn.IP, r.clock.Now().Sub(began), err)
+	}
+	// The caller gave up or ran out of time; the transport's own
+	// timeout is the only one that says anything about rn
+	if err == context.Canceled || (err != nil && ctx.Err() != nil) {
+		r.breakers.release(rn.IP)
+		return err
+	}
+	if err == nil && reply != nil {
+		reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(attempt).Elem())
+	}
+	// Errors returned by the peer's handler say nothing about its health
+	if PeerFailure(err) {
+		r.breakers.failure(rn.IP)
+		r.failed(rn, err)
+		return err
+	}
+	r.breakers.success(rn.IP)
+	r.detector.Heartbeat(rn.IP)
+	return err
+}
+
+// fresh Returns a new value of the type reply points to
+func fresh(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+// idempotent issues a call that is safe to repeat,
+// retrying it after transient errors
+func (r *Remote) idempotent(ctx context.Context, rn comm.Rnode, method string, args interface{}, reply interface{}) error {
+	err := r.call(ctx, rn, method, args, reply)
+	for n := 1; n < r.retry.policy.Attempts && transient(err); n++ {
+		if !r.retry.wait(ctx, n) {
+			return ctxErr(ctx)
 		}
-		r.conns[rn.IP] = conn
-		return conn, nil
+		err = r.call(ctx, rn, method, args, reply)
 	}
-	return val, nil
+	return err
 }
 
-func (r *Remote) GetSuccessor(rn comm.Rnode) (*comm.Rnode, error) {
-	c, err := r.get(rn)
-	if err != nil {
-		return nil, err
+// ctxErr Maps an expired deadline to ErrTimeout; cancellation is returned as is
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
 	}
+	return ctx.Err()
+}
 
-	//args := &comm.NodeID{ID: []byte(id)}
-	var reply comm.NodeID
-	err = c.Call("NodeComm.GetSuccessor", &comm.Empty{}, &reply)
-	if err != nil {
-		return nil, err
+// budget Returns the time left until ctx's deadline, or zero if it has none
+func budget(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
 	}
-	return &comm.Rnode{ID: util.StringToID(reply.ID), IP: reply.IP}, nil
+	// Zero would mean no deadline at all
+	if left := time.Until(deadline); left > 0 {
+		return left
+	}
+	return time.Nanosecond
 }
 
-func (r *Remote) GetPredecessor(rn comm.Rnode) (*comm.Rnode, error) {
-	c, err := r.get(rn)
-	if err != nil {
-		return nil, err
+// failed records a failed call to rn and hands it to the fail handler
+func (r *Remote) failed(rn comm.Rnode, err error) {
+	r.detector.Missed(rn.IP)
+	if r.fail != nil {
+		r.fail(&rn, err)
 	}
+}
+
+// Evict closes and forgets the cached connection to rn
+func (r *Remote) Evict(rn comm.Rnode) {
+	r.t.Evict(rn.IP)
+}
+
+// Close closes all cached connections
+func (r *Remote) Close() {
+	r.t.Close()
+}
+
+// Suspect reports whether rn is suspected to have failed,
+// by the failure detector or because its circuit is open
+func (r *Remote) Suspect(rn comm.Rnode) bool {
+	return r.detector.Suspect(rn.IP) || r.breakers.open(rn.IP)
+}
+
+// BreakerStats Returns the counters of the circuit breakers
+func (r *Remote) BreakerStats() BreakerStats {
+	return r.breakers.counts()
+}
+
+// Phi returns the suspicion level of rn
+func (r *Remote) Phi(rn comm.Rnode) float64 {
+	return r.detector.Phi(rn.IP)
+}
+
+func (r *Remote) GetSuccessor(ctx context.Context, rn comm.Rnode) (*comm.Rnode, error) {
 	//args := &comm.NodeID{ID: []byte(id)}
 	var reply comm.NodeID
-	err = c.Call("NodeComm.GetPredecessor", &comm.Empty{}, &reply)
+	err := r.idempotent(ctx, rn, "NodeComm.GetSuccessor", &comm.Empty{}, &reply)
 	if err != nil {
 		return nil, err
 	}
 	return &comm.Rnode{ID: util.StringToID(reply.ID), IP: reply.IP}, nil
 }
 
-func (r *Remote) FindPredecessor(rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
-	c, err := r.get(rn)
+// GetSuccessorList Retrieves rn's whole successor list in one call.
+// Peers predating the call only tell their immediate successor
+func (r *Remote) GetSuccessorList(ctx context.Context, rn comm.Rnode) ([]comm.Rnode, error) {
+	if v, err := r.t.Version(ctx, rn.IP); err == nil && v < versionSuccessorList {
+		succ, err := r.GetSuccessor(ctx, rn)
+		if err != nil {
+			return nil, err
+		}
+		return []comm.Rnode{*succ}, nil
+	}
+	var reply []comm.NodeID
+	err := r.idempotent(ctx, rn, "NodeComm.GetSuccessorList", &comm.Empty{}, &reply)
 	if err != nil {
 		return nil, err
 	}
+	list := make([]comm.Rnode, len(reply))
+	for i, s := range reply {
+		list[i] = comm.Rnode{ID: util.StringToID(s.ID), IP: s.IP}
+	}
+	return list, nil
+}
 
-	args := &comm.NodeID{ID: string(id)}
+func (r *Remote) GetPredecessor(ctx context.Context, rn comm.Rnode) (*comm.Rnode, error) {
+	//args := &comm.NodeID{ID: []byte(id)}
 	var reply comm.NodeID
-	err = c.Call("NodeComm.FindPredecessor", args, &reply)
+	err := r.idempotent(ctx, rn, "NodeComm.GetPredecessor", &comm.Empty{}, &reply)
 	if err != nil {
 		return nil, err
 	}
 	return &comm.Rnode{ID: util.StringToID(reply.ID), IP: reply.IP}, nil
 }
 
-func (r *Remote) FindSuccessor(rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
-	c, err := r.get(rn)
+func (r *Remote) FindPredecessor(ctx context.Context, rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
+	args := &comm.Args{ID: string(id), Timeout: budget(ctx)}
+	var reply comm.NodeID
+	err := r.idempotent(ctx, rn, "NodeComm.FindPredecessor", args, &reply)
 	if err != nil {
 		return nil, err
 	}
+	return &comm.Rnode{ID: util.StringToID(reply.ID), IP: reply.IP}, nil
+}
 
-	args := &comm.NodeID{ID: string(id)}
+func (r *Remote) FindSuccessor(ctx context.Context, rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
+	args := &comm.Args{ID: string(id), Timeout: budget(ctx)}
 	var reply comm.NodeID
-	err = c.Call("NodeComm.FindSuccessor", args, &reply)
+	err := r.idempotent(ctx, rn, "NodeComm.FindSuccessor", args, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -102,14 +247,9 @@ func (r *Remote) FindSuccessor(rn comm.Rnode, id util.Identifier) (*comm.Rnode,
 }
 
 // PutRemote Stores a value in its respective node
-func (r *Remote) PutRemote(rn comm.Rnode, key, value string) error {
-	c, err := r.get(rn)
-	if err != nil {
-		return err
-	}
-
+func (r *Remote) PutRemote(ctx context.Context, rn comm.Rnode, key, value string) error {
 	args := &comm.KeyValue{Key: key, Value: value}
-	err = c.Call("NodeComm.PutRemote", args, nil)
+	err := r.call(ctx, rn, "NodeComm.PutRemote", args, nil)
 	if err != nil {
 		return err
 	}
@@ -117,14 +257,10 @@ func (r *Remote) PutRemote(rn comm.Rnode, key, value string) error {
 }
 
 // PutRemote Stores a value in its respective node
-func (r *Remote) GetRemote(rn comm.Rnode, key string) (string, error) {
-	c, err := r.get(rn)
-	if err != nil {
-		return "", err
-	}
+func (r *Remote) GetRemote(ctx context.Context, rn comm.Rnode, key string) (string, error) {
 	args := &comm.KeyValue{Key: key}
 	reply := comm.KeyValue{}
-	err = c.Call("NodeComm.GetRemote", args, &reply)
+	err := r.idempotent(ctx, rn, "NodeComm.GetRemote", args, &reply)
 	if err != nil {
 		return "", err
 	}
@@ -132,27 +268,26 @@ func (r *Remote) GetRemote(rn comm.Rnode, key string) (string, error) {
 	return reply.Value, nil
 }
 
-func (r *Remote) UpdatePredecessor(rn comm.Rnode, id util.Identifier, ip string) error {
-	c, err := r.get(rn)
-	if err != nil {
-		return err
+// proof Proves membership of the node id at ip, if a secret is set
+func (r *Remote) proof(id util.Identifier, ip string) string {
+	if r.secret == "" {
+		return ""
 	}
-	args := &comm.NodeID{ID: string(id), IP: ip}
-	err = c.Call("NodeComm.UpdatePredecessor", args, nil)
-	if err != nil {
-		return err
-	}
-	return nil
+	return JoinProof(r.secret, id, ip)
 }
 
-func (r *Remote) UpdateSuccessor(rn comm.Rnode, id util.Identifier, ip string) error {
-	c, err := r.get(rn)
+func (r *Remote) UpdatePredecessor(ctx context.Context, rn comm.Rnode, id util.Identifier, ip string) error {
+	args := &comm.NodeID{ID: string(id), IP: ip, Proof: r.proof(id, ip)}
+	err := r.call(ctx, rn, "NodeComm.UpdatePredecessor", args, nil)
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	args := &comm.NodeID{ID: string(id), IP: ip}
-	err = c.Call("NodeComm.UpdateSuccessor", args, nil)
+func (r *Remote) UpdateSuccessor(ctx context.Context, rn comm.Rnode, id util.Identifier, ip string) error {
+	args := &comm.NodeID{ID: string(id), IP: ip, Proof: r.proof(id, ip)}
+	err := r.call(ctx, rn, "NodeComm.UpdateSuccessor", args, nil)
 	if err != nil {
 		return err
 
@@ -160,15 +295,16 @@ func (r *Remote) UpdateSuccessor(rn comm.Rnode, id util.Identifier, ip string) e
 	return nil
 }
 
-func (r *Remote) ClosestPreFinger(rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
-	c, err := r.get(rn)
-	if err != nil {
-		return nil, err
+// ClosestPreFinger Asks rn for its finger closest preceding id
+func (r *Remote) ClosestPreFinger(ctx context.Context, rn comm.Rnode, id util.Identifier) (*comm.Rnode, error) {
+	method := "NodeComm.ClosestPreFingerArgs"
+	var args interface{} = &comm.Args{ID: id.ToString()}
+	if v, err := r.t.Version(ctx, rn.IP); err == nil && v < versionClosestPreFingerArgs {
+		legacy := id.ToString()
+		method, args = "NodeComm.ClosestPreFinger", &legacy
 	}
-
-	args := id.ToString()
 	var reply comm.NodeID
-	err = c.Call("NodeComm.ClosestPreFinger", &args, &reply)
+	err := r.idempotent(ctx, rn, method, args, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -176,58 +312,44 @@ func (r *Remote) ClosestPreFinger(rn comm.Rnode, id util.Identifier) (*comm.Rnod
 	return &comm.Rnode{ID: util.StringToID(reply.ID), IP: reply.IP}, nil
 }
 
-func (r *Remote) UpdateFingerTable(rn comm.Rnode, s util.Identifier, ip string, idx int) error {
-	c, err := r.get(rn)
-	if err != nil {
-		return err
-	}
-
+func (r *Remote) UpdateFingerTable(ctx context.Context, rn comm.Rnode, s util.Identifier, ip string, idx int) error {
 	args := &comm.FingerEntry{
 		S:   comm.NodeID{ID: s.ToString(), IP: ip},
 		IDX: idx,
 	}
 
-	err = c.Call("NodeComm.UpdateFingerTable", args, nil)
+	err := r.call(ctx, rn, "NodeComm.UpdateFingerTable", args, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *Remote) GetKeysInInterval(rn comm.Rnode, from, to util.Identifier) (*map[string]string, error) {
-	c, err := r.get(rn)
-	if err != nil {
-		return nil, err
-	}
-
+func (r *Remote) GetKeysInInterval(ctx context.Context, rn comm.Rnode, from, to util.Identifier) (*map[string]string, error) {
 	args := &comm.Interval{
 		From: from.ToString(),
 		To:   to.ToString(),
 	}
 
 	reply := make(map[string]string)
-	err = c.Call("NodeComm.GetKeysInInterval", args, &reply)
+	err := r.call(ctx, rn, "NodeComm.GetKeysInInterval", args, &reply)
 	if err != nil {
 		return nil, err
 	}
 	return &reply, nil
 }
 
-func (r *Remote) Notify(rn comm.Rnode, node *comm.Rnode) error {
-	c, err := r.get(rn)
-	if err != nil {
-		return err
-	}
-
-	err = c.Call("NodeComm.Notify", node, &comm.Empty{})
+func (r *Remote) Notify(ctx context.Context, rn comm.Rnode, node *comm.Rnode) error {
+	args := &comm.Member{ID: node.ID, IP: node.IP, Proof: r.proof(node.ID, node.IP)}
+	err := r.call(ctx, rn, "NodeComm.Notify", args, &comm.Empty{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *Remote) IsAlive(rn comm.Rnode) (bool, error) {
-	_, err := net.DialTimeout("tcp", rn.IP+PORT, r.timeout)
+func (r *Remote) IsAlive(ctx context.Context, rn comm.Rnode) (bool, error) {
+	err := r.t.Ping(ctx, rn.IP)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,96 @@
+package netutils
+
+import (
+	"context"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+// RetryPolicy How idempotent calls are retried after transient errors.
+// Zero values pick the defaults
+type RetryPolicy struct {
+	// Attempts total tries per call, the first included. 1 disables retries
+	Attempts int
+	// MinBackoff wait before the first retry; doubles with every retry
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter fraction of the backoff that is randomized, between 0 and 1
+	Jitter float64
+}
+
+const (
+	defaultRetryAttempts   = 2
+	defaultRetryMinBackoff = time.Millisecond * 20
+	defaultRetryMaxBackoff = time.Millisecond * 500
+	defaultRetryJitter     = 0.5
+)
+
+func (p *RetryPolicy) setDefaults() {
+	if p.Attempts <= 0 {
+		p.Attempts = defaultRetryAttempts
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = defaultRetryMinBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = defaultRetryJitter
+	}
+}
+
+// retrier Waits between attempts of a call
+type retrier struct {
+	policy RetryPolicy
+	clock  util.Clock
+	mu     sync.Mutex
+	rand   *rand.Rand
+}
+
+func newRetrier(p RetryPolicy, clock util.Clock, seed int64) *retrier {
+	p.setDefaults()
+	return &retrier{policy: p, clock: clock, rand: rand.New(rand.NewSource(seed))}
+}
+
+// backoff Returns the wait before retry number n, counting from 1
+func (r *retrier) backoff(n int) time.Duration {
+	d := r.policy.MinBackoff << uint(n-1)
+	if d > r.policy.MaxBackoff || d <= 0 {
+		d = r.policy.MaxBackoff
+	}
+	r.mu.Lock()
+	f := 1 - r.policy.Jitter*r.rand.Float64()
+	r.mu.Unlock()
+	return time.Duration(float64(d) * f)
+}
+
+// wait sleeps before retry n. Returns false if ctx ends first
+func (r *retrier) wait(ctx context.Context, n int) bool {
+	select {
+	case <-r.clock.After(r.backoff(n)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// transient reports whether err may go away when the call is repeated
+func transient(err error) bool {
+	return err == ErrTimeout || broken(err)
+}
+
+// PeerFailure reports whether err says the peer could not be reached,
+// as opposed to the peer's handler failing or the caller giving up.
+// Lookups route around peers failing this way
+func PeerFailure(err error) bool {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	_, ok := err.(rpc.ServerError)
+	return !ok
+}
@@ -0,0 +1,47 @@
+package netutils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+var (
+	// ErrNoCACerts if a CA file holds no PEM certificates
+	ErrNoCACerts = errors.New("no certificates found in CA file")
+)
+
+// TLSFiles PEM files a node secures its connections with
+type TLSFiles struct {
+	// CertFile and KeyFile the node's own certificate and key
+	CertFile string
+	KeyFile  string
+	// CAFile the cluster CA that peers' certificates must be signed by
+	CAFile string
+}
+
+// LoadTLS Builds a mutual TLS configuration from f. The node presents
+// its certificate, and both ends of every connection require a
+// certificate signed by the cluster CA from the other
+func LoadTLS(f TLSFiles) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(f.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(pem) {
+		return nil, ErrNoCACerts
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		ClientCAs:    ca,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
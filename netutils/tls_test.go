@@ -0,0 +1,126 @@
+package netutils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA Signs certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.write(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) write(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue Writes a certificate for 127.0.0.1 signed by ca
+func (ca *testCA) issue(t *testing.T, name string) TLSFiles {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TLSFiles{
+		CertFile: ca.write(t, name+".pem", "CERTIFICATE", der),
+		KeyFile:  ca.write(t, name+"-key.pem", "EC PRIVATE KEY", kder),
+		CAFile:   filepath.Join(ca.dir, "ca.pem"),
+	}
+}
+
+func loadTLS(t *testing.T, f TLSFiles) PoolConfig {
+	conf, err := LoadTLS(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PoolConfig{Handshake: NewHandshake(""), TLS: conf, DialTimeout: time.Second}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "cluster")
+	server := loadTLS(t, ca.issue(t, "server"))
+	l, err := ListenRPC("127.0.0.1:0", &stubNode{succ: "s"}, server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+	ctx := context.Background()
+
+	c, err := dialRPC(ctx, addr, loadTLS(t, ca.issue(t, "client")))
+	if err != nil {
+		t.Fatalf("expected a peer of the cluster to connect, got %v", err)
+	}
+	c.c.Close()
+
+	// Certificates of another CA are refused, and so are clients without one
+	outsider := loadTLS(t, newTestCA(t, "outsider").issue(t, "client"))
+	if _, err := dialRPC(ctx, addr, outsider); err == nil {
+		t.Error("expected a certificate of another CA to be refused")
+	}
+	anonymous := loadTLS(t, ca.issue(t, "anonymous"))
+	anonymous.TLS.Certificates = nil
+	if _, err := dialRPC(ctx, addr, anonymous); err == nil {
+		t.Error("expected a client without certificate to be refused")
+	}
+	if _, err := dialRPC(ctx, addr, PoolConfig{Handshake: NewHandshake(""), DialTimeout: time.Second}); err == nil {
+		t.Error("expected a plaintext client to be refused")
+	}
+}
@@ -0,0 +1,106 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+// Transport Carries NodeComm calls between nodes. Remote issues every
+// peer call through a Transport, so nodes can talk over net/rpc or
+// over an in-memory network in tests
+type Transport interface {
+	// Listen serves api on addr
+	Listen(addr string, api comm.NodeComm) (Listener, error)
+	// Call invokes method (e.g. "NodeComm.GetSuccessor") on the node at addr.
+	// Errors returned by the peer's handler are rpc.ServerError. Once ctx's
+	// deadline passes Call returns ErrTimeout; if ctx is cancelled, ctx.Err()
+	Call(ctx context.Context, addr, method string, args interface{}, reply interface{}) error
+	// Ping checks whether a node is reachable at addr
+	Ping(ctx context.Context, addr string) error
+	// Version returns the protocol version spoken by the node at addr
+	Version(ctx context.Context, addr string) (int, error)
+	// Evict drops any cached connection to addr
+	Evict(addr string)
+	// Close drops all cached connections
+	Close() error
+}
+
+// Listener A NodeComm endpoint served by a Transport
+type Listener interface {
+	// Addr address peers should dial to reach the endpoint
+	Addr() string
+	Close() error
+}
+
+// RPCTransport Transport using net/rpc over tcp4.
+// Connections to peers are kept in a Pool
+type RPCTransport struct {
+	pool    *Pool
+	timeout time.Duration
+}
+
+// NewRPCTransport creates a net/rpc transport. Call timeouts,
+// idle connections and redial backoff are measured on clock
+func NewRPCTransport(clock util.Clock, cfg PoolConfig) *RPCTransport {
+	return &RPCTransport{
+		pool:    NewPool(cfg, clock),
+		timeout: time.Duration(time.Second * 1),
+	}
+}
+
+type rpcEndpoint struct {
+	net.Listener
+}
+
+func (e rpcEndpoint) Addr() string {
+	return e.Listener.Addr().String()
+}
+
+// Listen Serves api over net/rpc on addr, secured
+// the same way as the transport's connections
+func (t *RPCTransport) Listen(addr string, api comm.NodeComm) (Listener, error) {
+	l, err := ListenRPC(addr, api, t.pool.cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return rpcEndpoint{l}, nil
+}
+
+// Call Issues method on the node at addr over a pooled connection
+func (t *RPCTransport) Call(ctx context.Context, addr, method string, args interface{}, reply interface{}) error {
+	return t.pool.Call(ctx, addr, method, args, reply)
+}
+
+// Ping Dials addr without issuing a call
+func (t *RPCTransport) Ping(ctx context.Context, addr string) error {
+	d := net.Dialer{Timeout: t.timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Version Returns the version addr announced when its connection was set up
+func (t *RPCTransport) Version(ctx context.Context, addr string) (int, error) {
+	return t.pool.Version(ctx, addr)
+}
+
+// Evict Closes the pooled connections to addr
+func (t *RPCTransport) Evict(addr string) {
+	t.pool.Evict(addr)
+}
+
+// Close Closes all pooled connections
+func (t *RPCTransport) Close() error {
+	return t.pool.Close()
+}
+
+// Stats Returns the connection pool's counters
+func (t *RPCTransport) Stats() PoolStats {
+	return t.pool.Stats()
+}
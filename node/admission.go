@@ -0,0 +1,54 @@
+package node
+
+import (
+	"sync"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/util"
+)
+
+// admissionCounts Snapshot of rejected membership announcements
+type admissionCounts struct {
+	Rejected int
+	// Rejections per announced address
+	Peers map[string]int
+}
+
+// admissionLog Counts announcements lacking proof of the cluster secret
+type admissionLog struct {
+	sync.Mutex
+	c admissionCounts
+}
+
+func newAdmissionLog() *admissionLog {
+	return &admissionLog{c: admissionCounts{Peers: make(map[string]int)}}
+}
+
+func (a *admissionLog) rejected(ip string) {
+	a.Lock()
+	a.c.Rejected++
+	a.c.Peers[ip]++
+	a.Unlock()
+}
+
+func (a *admissionLog) counts() admissionCounts {
+	a.Lock()
+	defer a.Unlock()
+	c := a.c
+	c.Peers = make(map[string]int, len(a.c.Peers))
+	for ip, cnt := range a.c.Peers {
+		c.Peers[ip] = cnt
+	}
+	return c
+}
+
+// admit Checks the proof of the node id at ip, announced through method.
+// Rejections are logged and counted
+func (n *Node) admit(method string, id util.Identifier, ip, proof string) error {
+	if netutils.VerifyJoinProof(n.cfg.ClusterSecret, id, ip, proof) {
+		return nil
+	}
+	n.admissions.rejected(ip)
+	n.log.Err.Printf("Rejected %s announcing %s: %v\n", method, ip, netutils.ErrNotAdmitted)
+	return netutils.ErrNotAdmitted
+}
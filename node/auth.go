@@ -0,0 +1,252 @@
+package node
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Right Access a token grants to the HTTP API
+type Right int
+
+const (
+	// RightRead GET keys
+	RightRead Right = 1 << iota
+	// RightWrite PUT keys
+	RightWrite
+	// RightAdmin node state and administrative routes such as leave
+	RightAdmin
+)
+
+const (
+	// DateHeader unix time a signed request was made at
+	DateHeader = "X-Chord-Date"
+	// maxSkew how far the date of a signed request may be off
+	maxSkew = time.Minute * 5
+)
+
+var (
+	ErrNoCredentials  = errors.New("missing credentials")
+	ErrBadCredentials = errors.New("invalid credentials")
+	ErrBadSignature   = errors.New("invalid request signature")
+	ErrStaleRequest   = errors.New("request date is missing or too far off")
+	ErrForbidden      = errors.New("token does not grant access")
+	ErrUnknownRight   = errors.New("unknown right")
+)
+
+// ParseRight Returns the right named s: read, write or admin
+func ParseRight(s string) (Right, error) {
+	switch s {
+	case "read":
+		return RightRead, nil
+	case "write":
+		return RightWrite, nil
+	case "admin":
+		return RightAdmin, nil
+	}
+	return 0, ErrUnknownRight
+}
+
+// Token Credentials of an API client
+type Token struct {
+	// Name identifies the client in the audit log and in signed requests
+	Name string
+	// Secret sent as a bearer token, or the key requests are signed with
+	Secret string
+	Rights Right
+	// Prefixes keys the token may read or write. Empty allows all keys
+	Prefixes []string
+}
+
+// allows reports whether t grants right on key
+func (t *Token) allows(right Right, key string) bool {
+	if t.Rights&right == 0 {
+		return false
+	}
+	if right == RightAdmin || len(t.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth Authenticates requests to the HTTP API and authorizes them
+// against the rights of their token. Requests either carry the
+// secret as "Authorization: Bearer <secret>", or are signed with
+// "Authorization: HMAC <name>:<signature>", see SignRequest
+type Auth struct {
+	tokens []*Token
+	// Audit receives denied requests. Nodes default it to their error log
+	Audit *log.Logger
+}
+
+// NewAuth creates an Auth accepting tokens
+func NewAuth(tokens []Token, audit *log.Logger) *Auth {
+	a := &Auth{Audit: audit}
+	for i := range tokens {
+		a.tokens = append(a.tokens, &tokens[i])
+	}
+	return a
+}
+
+// LoadAuth Reads tokens from a JSON file holding a list of
+// {"Name", "Secret", "Rights": ["read", ...], "Prefixes": [...]}
+func LoadAuth(path string, audit *log.Logger) (*Auth, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file []struct {
+		Name     string
+		Secret   string
+		Rights   []string
+		Prefixes []string
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	tokens := make([]Token, len(file))
+	for i, f := range file {
+		if f.Name == "" || f.Secret == "" {
+			return nil, fmt.Errorf("token %d: name and secret must be set", i)
+		}
+		tokens[i] = Token{Name: f.Name, Secret: f.Secret, Prefixes: f.Prefixes}
+		for _, s := range f.Rights {
+			r, err := ParseRight(s)
+			if err != nil {
+				return nil, fmt.Errorf("token %s: %v %q", f.Name, err, s)
+			}
+			tokens[i].Rights |= r
+		}
+	}
+	return NewAuth(tokens, audit), nil
+}
+
+// authenticate Returns the token r was made with
+func (a *Auth) authenticate(r *http.Request, now time.Time) (*Token, error) {
+	h := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(h, "Bearer "):
+		secret := []byte(strings.TrimPrefix(h, "Bearer "))
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(secret, []byte(t.Secret)) == 1 {
+				return t, nil
+			}
+		}
+		return nil, ErrBadCredentials
+	case strings.HasPrefix(h, "HMAC "):
+		cred := strings.SplitN(strings.TrimPrefix(h, "HMAC "), ":", 2)
+		if len(cred) != 2 {
+			return nil, ErrBadCredentials
+		}
+		name, sig := cred[0], cred[1]
+		for _, t := range a.tokens {
+			if t.Name == name {
+				return t, verifySignature(r, t.Secret, sig, now)
+			}
+		}
+		return nil, ErrBadCredentials
+	}
+	return nil, ErrNoCredentials
+}
+
+// signature Computes the signature of a request made at date
+func signature(secret, method, path, date string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, path, date, hex.EncodeToString(sum[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks sig and the date of r, leaving r's body readable
+func verifySignature(r *http.Request, secret, sig string, now time.Time) error {
+	date := r.Header.Get(DateHeader)
+	unix, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return ErrStaleRequest
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return ErrStaleRequest
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	want := signature(secret, r.Method, r.URL.EscapedPath(), date, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// SignRequest Signs r, made at now, with the token name and secret.
+// The body is read and replaced so that r can still be sent
+func SignRequest(r *http.Request, name, secret string, now time.Time) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	date := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(DateHeader, date)
+	r.Header.Set("Authorization", "HMAC "+name+":"+signature(secret, r.Method, r.URL.EscapedPath(), date, body))
+	return nil
+}
+
+// deny Writes the audit log entry of a denied request
+func (a *Auth) deny(r *http.Request, t *Token, err error) {
+	if a.Audit == nil {
+		return
+	}
+	name := "-"
+	if t != nil {
+		name = t.Name
+	}
+	a.Audit.Printf("DENY %s %s %s %s: %v\n", r.RemoteAddr, name, r.Method, r.URL.Path, err)
+}
+
+// authorize wraps h so that it only serves requests whose token
+// grants right. Access to keys is also limited by the token's prefixes.
+// Without an Auth configured every request is served
+func (n *Node) authorize(right Right, h http.HandlerFunc) http.HandlerFunc {
+	a := n.cfg.Auth
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := a.authenticate(r, n.clock.Now())
+		if err != nil {
+			a.deny(r, t, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chord"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !t.allows(right, readKey(r)) {
+			a.deny(r, t, ErrForbidden)
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,110 @@
+package node
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hoffa2/chord/util"
+)
+
+func authRouter(t *testing.T, audit *bytes.Buffer) (*mux.Router, *util.FakeClock) {
+	clock := util.NewFakeClock(time.Unix(1000, 0))
+	n, err := New(Config{
+		Log:   DiscardLogger(),
+		Clock: clock,
+		Auth: NewAuth([]Token{
+			{Name: "reader", Secret: "r", Rights: RightRead, Prefixes: []string{"users-"}},
+			{Name: "ops", Secret: "o", Rights: RightRead | RightWrite | RightAdmin},
+		}, log.New(audit, "", 0)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/state/get", n.authorize(RightAdmin, ok)).Methods("GET")
+	r.HandleFunc("/{key}", n.authorize(RightRead, ok)).Methods("GET")
+	r.HandleFunc("/{key}", n.authorize(RightWrite, ok)).Methods("PUT")
+	return r, clock
+}
+
+func serve(r *mux.Router, req *http.Request) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAuthBearer(t *testing.T) {
+	var audit bytes.Buffer
+	r, _ := authRouter(t, &audit)
+	cases := []struct {
+		method, path, token string
+		code                int
+	}{
+		{"GET", "/users-1", "", http.StatusUnauthorized},
+		{"GET", "/users-1", "wrong", http.StatusUnauthorized},
+		{"GET", "/users-1", "r", http.StatusOK},
+		{"GET", "/orders-1", "r", http.StatusForbidden},
+		{"PUT", "/users-1", "r", http.StatusForbidden},
+		{"GET", "/state/get", "r", http.StatusForbidden},
+		{"PUT", "/orders-1", "o", http.StatusOK},
+		{"GET", "/state/get", "o", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if code := serve(r, req); code != c.code {
+			t.Errorf("%s %s with %q: expected %d, got %d", c.method, c.path, c.token, c.code, code)
+		}
+	}
+	if lines := strings.Count(audit.String(), "DENY"); lines != 5 {
+		t.Errorf("expected 5 denied requests in the audit log, got:\n%s", audit.String())
+	}
+}
+
+func TestAuthHMAC(t *testing.T) {
+	var audit bytes.Buffer
+	r, clock := authRouter(t, &audit)
+
+	req := httptest.NewRequest("PUT", "/k", strings.NewReader("value"))
+	SignRequest(req, "ops", "o", clock.Now())
+	if code := serve(r, req); code != http.StatusOK {
+		t.Errorf("expected a signed request to be accepted, got %d", code)
+	}
+
+	req = httptest.NewRequest("PUT", "/k", strings.NewReader("value"))
+	SignRequest(req, "ops", "o", clock.Now())
+	req.Body = http.NoBody
+	if code := serve(r, req); code != http.StatusUnauthorized {
+		t.Errorf("expected a tampered body to be refused, got %d", code)
+	}
+
+	req = httptest.NewRequest("GET", "/k", nil)
+	SignRequest(req, "ops", "o", clock.Now())
+	clock.Advance(maxSkew + time.Second)
+	if code := serve(r, req); code != http.StatusUnauthorized {
+		t.Errorf("expected a stale request to be refused, got %d", code)
+	}
+	if !strings.Contains(audit.String(), ErrStaleRequest.Error()) {
+		t.Errorf("expected the stale request to be audited, got:\n%s", audit.String())
+	}
+}
+
+func TestAuthShared(t *testing.T) {
+	auth := NewAuth([]Token{{Name: "ops", Secret: "o", Rights: RightAdmin}}, nil)
+	n, err := New(Config{Log: DiscardLogger(), Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Audit != nil || n.cfg.Auth.Audit == nil {
+		t.Error("expected the audit log to be defaulted on the node's copy only")
+	}
+}
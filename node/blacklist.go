@@ -0,0 +1,119 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+const (
+	// DefaultBlacklistFor how long a node claiming a foreign identifier is ignored
+	DefaultBlacklistFor = time.Minute * 10
+)
+
+// blacklistCounts Snapshot of nodes rejected in strict mode
+type blacklistCounts struct {
+	// Listed address and hex identifier of each blacklisted claim
+	Listed []string
+	// Rejected nodes, including those already blacklisted
+	Rejected int
+}
+
+// claim An address together with the identifier claimed for it
+type claim struct {
+	ip string
+	id string
+}
+
+func claimOf(rn comm.Rnode) claim {
+	return claim{ip: rn.IP, id: string(rn.ID)}
+}
+
+// blacklist Identifiers claimed for an address other than its hash,
+// and until when nodes carrying them are ignored. Claims are keyed by
+// both, so anyone can forge a claim in a peer's name without getting
+// the peer itself blacklisted
+type blacklist struct {
+	sync.Mutex
+	until    map[claim]time.Time
+	rejected int
+}
+
+func newBlacklist() *blacklist {
+	return &blacklist{until: make(map[claim]time.Time)}
+}
+
+// listed reports whether c is blacklisted at now, counting a rejection if so
+func (b *blacklist) listed(c claim, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	until, ok := b.until[c]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(b.until, c)
+		return false
+	}
+	b.rejected++
+	return true
+}
+
+func (b *blacklist) add(c claim, until time.Time) {
+	b.Lock()
+	b.until[c] = until
+	b.rejected++
+	b.Unlock()
+}
+
+func (b *blacklist) counts(now time.Time) blacklistCounts {
+	b.Lock()
+	defer b.Unlock()
+	c := blacklistCounts{Rejected: b.rejected, Listed: []string{}}
+	for cl, until := range b.until {
+		if now.Before(until) {
+			c.Listed = append(c.Listed, fmt.Sprintf("%s %x", cl.ip, cl.id))
+		}
+	}
+	return c
+}
+
+// ownsID reports whether rn's identifier is the hash of its address
+func ownsID(rn comm.Rnode) bool {
+	return rn.ID.IsEqual(util.StringToID(util.HashValue(rn.IP)))
+}
+
+// trusted reports whether rn, learned through via, may become a
+// neighbor or finger. Outside strict mode every node may. In strict
+// mode rn's identifier must be the hash of its address; records
+// claiming another identifier for it are blacklisted for a while
+func (n *Node) trusted(rn comm.Rnode, via string) bool {
+	if !n.cfg.StrictIDs || rn.ID.IsEqual(n.ID) {
+		return true
+	}
+	now := n.clock.Now()
+	if n.blacklist.listed(claimOf(rn), now) {
+		return false
+	}
+	if ownsID(rn) {
+		return true
+	}
+	n.blacklist.add(claimOf(rn), now.Add(n.cfg.BlacklistFor))
+	n.log.Err.Printf("Blacklisting %s learned through %s: claims ID %x, which is not the hash of its address\n",
+		rn.IP, via, []byte(rn.ID))
+	return false
+}
+
+// trustedOnly Returns the nodes of list that are trusted
+func (n *Node) trustedOnly(list []comm.Rnode, via string) []comm.Rnode {
+	out := list[:0:0]
+	for _, rn := range list {
+		if n.trusted(rn, via) {
+			out = append(out, rn)
+		}
+	}
+	return out
+}
@@ -1,12 +1,16 @@
 package node
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/hoffa2/chord/comm"
 	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/tracing"
 	"github.com/hoffa2/chord/util"
 )
 
@@ -18,6 +22,10 @@ type FingerEntry struct {
 }
 
 const (
+	// Time an HTTP request may take, lookups included
+	requestTimeout = time.Second * 10
+	// Upper bound on the number of nodes visited by one lookup
+	maxLookupHops = 160
 	// NoValue if a put request does not have a body
 	NoValue = "No value in body"
 	// NotFound If key cannot be found
@@ -28,8 +36,12 @@ const (
 
 var (
 	// Keysize size of keyspace
-	KeySize         = 160
-	ErrInvalidIndex = errors.New("ftable index is invalid")
+	KeySize = 160
+	// DefaultSuccessors default length of the successor list
+	DefaultSuccessors = 4
+	ErrInvalidIndex   = errors.New("ftable index is invalid")
+	// ErrInvalidID if a configured identifier has the wrong size
+	ErrInvalidID = errors.New("Identifier must be KeySize bits long")
 	// ErrNotFound if key does not exist
 	ErrNotFound = errors.New("No value on key")
 	// ErrNextToSmall if the successor is too small
@@ -38,6 +50,12 @@ var (
 	ErrPrevToLarge = errors.New("Predecessor is larger than n id")
 	ErrExhausted   = errors.New("Successor list has exhausted")
 	ErrNotFirst    = errors.New("Successor provided is not first")
+	// ErrLookupFailed if a lookup did not converge within maxLookupHops
+	ErrLookupFailed = errors.New("Lookup did not converge")
+	// ErrAdminOnly if leaving is requested over RPC while the HTTP API requires authentication
+	ErrAdminOnly = errors.New("Leave requires admin rights; use POST /admin/leave")
+	// ErrUntrustedID if a node's identifier is not the hash of its address in strict mode
+	ErrUntrustedID = errors.New("Identifier is not the hash of the node's address")
 )
 
 // Neighbor Describing an adjacent node in the ring
@@ -45,6 +63,7 @@ var (
 // Node Interface struct that represents the state
 // of one node
 type Node struct {
+	cfg Config
 	// Storing key-value pairs on the respective node
 	mu  sync.RWMutex
 	nMu sync.RWMutex
@@ -54,6 +73,8 @@ type Node struct {
 	objectStore map[string]string
 	// IP Address of nameserver
 	nameServer string
+	// Address clients reach the HTTP API on
+	httpAddr string
 	// Address if graph frontend
 	graphIP string
 	conn    http.Client
@@ -63,10 +84,44 @@ type Node struct {
 	prev *comm.Rnode
 	// RPC connection wrapper
 	remote *netutils.Remote
-	// Channel to signal a leaving node
-	exitChan chan string
-	// Successor list
-	successors []comm.Rnode
+	// Announced to peers when connections are set up
+	handshake comm.Handshake
+	// Channel to trigger an immediate stabilize
+	stabilizeNow chan struct{}
+	// Closed when the node shuts down
+	quit chan struct{}
+	// Cancelled when the node shuts down; used by the node's own calls
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	// RPC listener and HTTP server
+	listener netutils.Listener
+	srv      *http.Server
+	// Failed peer calls seen by this node
+	failures *failureLog
+	// Announcements rejected for lacking proof of the cluster secret
+	admissions *admissionLog
+	// Nodes rejected in strict mode
+	blacklist *blacklist
+	// Lookups started by this node and their latencies
+	lookups *lookupLog
+	// When the stabilize routine last ran
+	stabilized *stabilizeLog
+	// Served at /metrics
+	metrics *nodeMetrics
+	// Records spans of traced requests; nil if tracing is disabled
+	tracer *tracing.Tracer
+	// Nodes seen before, probed to find diverged rings
+	peers     *peerSet
+	merges    *mergeLog
+	lastProbe time.Time
+	// Successor list, nearest first and at most nSuccessors long
+	successors  []comm.Rnode
+	nSuccessors int
+	// Times the stabilize routine and state reports
+	clock util.Clock
+	// Random finger choice; only used by the stabilize routine
+	rand *rand.Rand
 	// Logger
 	log *Logger
 	//
@@ -0,0 +1,215 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/tracing"
+	"github.com/hoffa2/chord/util"
+)
+
+const (
+	// DefaultStabilizeInterval period of the stabilize routine
+	DefaultStabilizeInterval = time.Second * 1
+	// DefaultGraphAddr address of the js frontend
+	DefaultGraphAddr = "129.242.22.74:8080"
+)
+
+// Config Everything needed to set up a node
+type Config struct {
+	// ID position on the ring. Derived from Advertise when not set
+	ID util.Identifier
+	// RPCAddr address the RPC server listens on, e.g. ":8011".
+	// Port 0 picks an ephemeral port
+	RPCAddr string
+	// HTTPAddr address the key-value API listens on. Empty disables it
+	HTTPAddr string
+	// Advertise host:port other nodes should dial.
+	// Defaults to the address the RPC server is bound to
+	Advertise string
+	// AdvertiseHTTP host:port clients should use for the key-value API.
+	// Defaults to the advertised host and the bound HTTP port
+	AdvertiseHTTP string
+	// NameServer address of the nameserver; only used by Run
+	NameServer string
+	// Ring name of the ring. Nodes only talk to peers on the same ring
+	Ring string
+	// ClusterSecret admits nodes to the ring. When set, registering with
+	// the nameserver and announcing neighbors requires proof of it.
+	// Mutual TLS (see TLS) admits peers by their certificate instead
+	ClusterSecret string
+	// StrictIDs only accepts neighbors and fingers whose identifier is the
+	// hash of their advertised address, and requires the same of this node
+	StrictIDs bool
+	// BlacklistFor how long nodes failing the strict check are ignored
+	BlacklistFor time.Duration
+	// Successors length of the successor list
+	Successors int
+	// PhiThreshold suspicion level at which peers are considered failed.
+	// Zero disables suspicion; Run uses netutils.DefaultPhiThreshold
+	PhiThreshold float64
+	// StabilizeInterval period of the stabilize routine
+	StabilizeInterval time.Duration
+	// Graph reports state to the js frontend at GraphAddr
+	Graph     bool
+	GraphAddr string
+	// Log defaults to colored output on stdout/stderr
+	Log *Logger
+	// Transport carries all peer calls. Defaults to net/rpc over tcp
+	Transport netutils.Transport
+	// Auth requires HTTP API requests to carry a token. Nil leaves the API
+	// open. Leaving over RPC is refused once set; use /admin/leave
+	Auth *Auth
+	// TLS secures the default transport with mutual TLS and serves the
+	// HTTP API over TLS, see netutils.LoadTLS. Nil leaves both plaintext
+	TLS *tls.Config
+	// Pool tunes the connection pool of the default transport
+	Pool netutils.PoolConfig
+	// Lookup runs redundant lookups, see LookupConfig
+	Lookup LookupConfig
+	// Retry policy of idempotent peer calls
+	Retry netutils.RetryPolicy
+	// Breaker tunes the per-peer circuit breakers
+	Breaker netutils.BreakerConfig
+	// Clock drives the stabilize routine and the failure detector.
+	// Defaults to the wall clock
+	Clock util.Clock
+	// Seed seeds the node's random choices. Zero picks a time based seed
+	Seed int64
+	// ProbeInterval how often a random known peer is asked whether it
+	// is on the same ring, to merge rings after a partition. Zero uses
+	// DefaultProbeInterval; negative disables probing
+	ProbeInterval time.Duration
+	// Trace receives the spans of the requests this node serves, see
+	// tracing.NewFileExporter. Nil disables tracing
+	Trace tracing.Exporter
+	// Manual disables the stabilize routine. The owner drives the node
+	// by calling Stabilize, as the simulator does
+	Manual bool
+}
+
+// Logger Info and error logs of a node
+type Logger struct {
+	Err  *log.Logger
+	Info *log.Logger
+}
+
+// NewLogger creates a logger prefixing lines with name
+func NewLogger(name string, info, err io.Writer) *Logger {
+	return &Logger{
+		Info: log.New(info, "\x1b[32m"+name+"\x1b[0m"+" --> ", log.Lshortfile),
+		Err:  log.New(err, "\x1b[31m"+name+"\x1b[0m"+" --> ", log.Lshortfile),
+	}
+}
+
+// DiscardLogger drops all output
+func DiscardLogger() *Logger {
+	return NewLogger("", io.Discard, io.Discard)
+}
+
+// New creates a node from cfg. Nothing is started until Start is called
+func New(cfg Config) (*Node, error) {
+	if cfg.RPCAddr == "" {
+		cfg.RPCAddr = ":" + netutils.DefaultRPCPort
+	}
+	if cfg.Successors <= 0 {
+		cfg.Successors = DefaultSuccessors
+	}
+	if cfg.StabilizeInterval <= 0 {
+		cfg.StabilizeInterval = DefaultStabilizeInterval
+	}
+	if cfg.GraphAddr == "" {
+		cfg.GraphAddr = DefaultGraphAddr
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = util.RealClock{}
+	}
+	cfg.Pool.Handshake = netutils.NewHandshake(cfg.Ring)
+	cfg.Pool.TLS = cfg.TLS
+	if cfg.Transport == nil {
+		cfg.Transport = netutils.NewRPCTransport(cfg.Clock, cfg.Pool)
+	}
+	if cfg.ProbeInterval == 0 {
+		cfg.ProbeInterval = DefaultProbeInterval
+	}
+	if cfg.BlacklistFor <= 0 {
+		cfg.BlacklistFor = DefaultBlacklistFor
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.ID != nil && len(cfg.ID) != KeySize/8 {
+		return nil, ErrInvalidID
+	}
+	// The audit log is defaulted below on the node's own copy, so
+	// nodes sharing an Auth each log to their own error log
+	if cfg.Auth != nil {
+		a := *cfg.Auth
+		cfg.Auth = &a
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		cfg:         cfg,
+		nameServer:  cfg.NameServer,
+		Rnode:       &comm.Rnode{ID: cfg.ID, IP: cfg.Advertise},
+		objectStore: make(map[string]string),
+		conn: http.Client{
+			Timeout: time.Duration(time.Second * 3),
+		},
+		fingers:      make([]FingerEntry, KeySize),
+		log:          cfg.Log,
+		nSuccessors:  cfg.Successors,
+		stabilizeNow: make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		failures:     newFailureLog(),
+		admissions:   newAdmissionLog(),
+		blacklist:    newBlacklist(),
+		lookups:      newLookupLog(),
+		stabilized:   &stabilizeLog{},
+		peers:        &peerSet{},
+		merges:       &mergeLog{},
+		graphIP:      cfg.GraphAddr,
+		graph:        cfg.Graph,
+		rand:         rand.New(rand.NewSource(cfg.Seed)),
+		clock:        cfg.Clock,
+		handshake:    cfg.Pool.Handshake,
+	}
+	if n.log == nil {
+		name := cfg.Advertise
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+		n.log = NewLogger(name, os.Stdout, os.Stderr)
+	}
+	n.metrics = newNodeMetrics(n)
+	if cfg.Trace != nil {
+		n.tracer = tracing.NewTracer(cfg.Advertise, cfg.Clock, cfg.Trace)
+	}
+	if n.cfg.Auth != nil && n.cfg.Auth.Audit == nil {
+		n.cfg.Auth.Audit = n.log.Err
+	}
+	n.remote = netutils.NewRemote(cfg.Transport, n.failhandler,
+		netutils.NewPhiDetector(cfg.PhiThreshold, cfg.Clock),
+		netutils.RemoteConfig{
+			Retry:   cfg.Retry,
+			Breaker: cfg.Breaker,
+			Clock:   cfg.Clock,
+			Seed:    cfg.Seed,
+			Log:     n.log.Info,
+			Secret:  cfg.ClusterSecret,
+			Observe: n.metrics.rpc,
+			Tracer:  n.tracer,
+		})
+	return n, nil
+}
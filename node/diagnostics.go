@@ -0,0 +1,122 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+// Hop One peer call made while routing a lookup
+type Hop struct {
+	// Path lookup path the call belongs to; parallel and hedged
+	// lookups follow several
+	Path    int
+	Node    string
+	ID      string
+	RPC     string
+	Latency time.Duration
+	Error   string `json:",omitempty"`
+	// SkippedTo node the lookup routed to after the call failed
+	SkippedTo string `json:",omitempty"`
+}
+
+// LookupTrace How a node routed the lookup of a key, as served
+// by GET /_lookup/{key}
+type LookupTrace struct {
+	Key string
+	// ID hashed key, hex encoded
+	ID string
+	// Node that ran the lookup
+	Node    string
+	Owner   string `json:",omitempty"`
+	OwnerID string `json:",omitempty"`
+	Latency time.Duration
+	Hops    []Hop
+	Error   string `json:",omitempty"`
+}
+
+// hopLog Collects the hops of a traced lookup
+type hopLog struct {
+	sync.Mutex
+	hops []Hop
+}
+
+type hopKey struct{}
+
+// hopTrace Log and path of a traced lookup, carried in its context
+type hopTrace struct {
+	log  *hopLog
+	path int
+}
+
+// withHops Makes lookups run with ctx record their hops in l
+func withHops(ctx context.Context, l *hopLog) context.Context {
+	return context.WithValue(ctx, hopKey{}, hopTrace{log: l})
+}
+
+// onPath Marks the hops of lookups run with ctx as taken on path
+func onPath(ctx context.Context, path int) context.Context {
+	t, ok := ctx.Value(hopKey{}).(hopTrace)
+	if !ok {
+		return ctx
+	}
+	t.path = path
+	return context.WithValue(ctx, hopKey{}, t)
+}
+
+// traceHop Records a call to rn begun at began if ctx is traced.
+// skip is the node routed to instead when the call failed
+func (n *Node) traceHop(ctx context.Context, rpc string, rn *comm.Rnode, began time.Time, err error, skip *comm.Rnode) {
+	t, ok := ctx.Value(hopKey{}).(hopTrace)
+	if !ok {
+		return
+	}
+	h := Hop{
+		Path:    t.path,
+		Node:    rn.IP,
+		ID:      hex.EncodeToString(rn.ID),
+		RPC:     rpc,
+		Latency: n.clock.Now().Sub(began),
+	}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	if skip != nil {
+		h.SkippedTo = skip.IP
+	}
+	t.log.Lock()
+	t.log.hops = append(t.log.hops, h)
+	t.log.Unlock()
+}
+
+// lookupKey Reports the owner of a key and the hops taken to find it
+func (n *Node) lookupKey(w http.ResponseWriter, r *http.Request) {
+	key := readKey(r)
+	id := util.StringToID(util.HashValue(key))
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	hops := &hopLog{}
+	began := n.clock.Now()
+	s, err := n.findKeySuccessor(withHops(ctx, hops), id)
+
+	t := LookupTrace{
+		Key:     key,
+		ID:      hex.EncodeToString(id),
+		Node:    n.IP,
+		Latency: n.clock.Now().Sub(began),
+		Hops:    hops.hops,
+	}
+	if err != nil {
+		t.Error = err.Error()
+	} else {
+		t.Owner = s.IP
+		t.OwnerID = hex.EncodeToString(s.ID)
+	}
+	util.WriteJson(w, t)
+}
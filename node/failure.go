@@ -0,0 +1,119 @@
+package node
+
+import (
+	"sync"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+)
+
+// failureCounts Snapshot of failed peer calls, exposed for debugging churn
+type failureCounts struct {
+	Total int
+	// Failures per peer address
+	Peers map[string]int
+	// Number of times a peer was purged from the routing state
+	Purged int
+}
+
+// failureLog Counts failed calls to peers
+type failureLog struct {
+	sync.Mutex
+	c failureCounts
+}
+
+func newFailureLog() *failureLog {
+	return &failureLog{c: failureCounts{Peers: make(map[string]int)}}
+}
+
+func (f *failureLog) add(ip string) {
+	f.Lock()
+	f.c.Total++
+	f.c.Peers[ip]++
+	f.Unlock()
+}
+
+func (f *failureLog) purged() {
+	f.Lock()
+	f.c.Purged++
+	f.Unlock()
+}
+
+func (f *failureLog) counts() failureCounts {
+	f.Lock()
+	defer f.Unlock()
+	c := f.c
+	c.Peers = make(map[string]int, len(f.c.Peers))
+	for ip, cnt := range f.c.Peers {
+		c.Peers[ip] = cnt
+	}
+	return c
+}
+
+// Callback invoked by Remote when a call to rn fails.
+// The cached connection is always dropped. Routing state is purged
+// when the connection itself broke, or when a timing out peer has
+// become suspect; a single slow response is left to the failure detector
+func (n *Node) failhandler(rn *comm.Rnode, err error) {
+	n.failures.add(rn.IP)
+	n.remote.Evict(*rn)
+
+	if rn.ID == nil || rn.ID.IsEqual(n.ID) {
+		return
+	}
+	if err == netutils.ErrTimeout && !n.remote.Suspect(*rn) {
+		return
+	}
+	if n.purge(rn) {
+		n.log.Info.Printf("Purged failed peer %s\n", rn.IP)
+		n.failures.purged()
+		n.triggerStabilize()
+	}
+}
+
+// purge removes rn from the fingertable, the successor list and
+// the predecessor. Reports whether rn was found anywhere
+func (n *Node) purge(rn *comm.Rnode) bool {
+	n.nMu.Lock()
+	defer n.nMu.Unlock()
+
+	found := false
+	succs := make([]comm.Rnode, 0, len(n.successors))
+	for _, s := range n.successors {
+		if s.ID.IsEqual(rn.ID) {
+			found = true
+			continue
+		}
+		succs = append(succs, s)
+	}
+	n.successors = succs
+
+	for i := range n.fingers {
+		if n.fingers[i].node == nil || !n.fingers[i].node.ID.IsEqual(rn.ID) {
+			continue
+		}
+		found = true
+		if i > 0 {
+			n.fingers[i].node = nil
+		} else if len(n.successors) > 0 {
+			next := n.successors[0]
+			n.fingers[0].node = &next
+		} else {
+			n.fingers[0].node = n.Rnode
+		}
+	}
+
+	if n.prev != nil && n.prev.ID.IsEqual(rn.ID) {
+		found = true
+		n.prev = n.Rnode
+	}
+	return found
+}
+
+// Wakes up the stabilize loop without waiting for the next period
+func (n *Node) triggerStabilize() {
+	select {
+	case n.stabilizeNow <- struct{}{}:
+	default:
+	}
+}
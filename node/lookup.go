@@ -0,0 +1,309 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+const (
+	// lookupSamples number of recent lookup latencies hedging is based on
+	lookupSamples = 128
+	// minHedgeSamples lookups needed before backups are started
+	minHedgeSamples = 16
+)
+
+// ErrNoQuorum if the paths of a parallel lookup disagreed on the successor
+var ErrNoQuorum = errors.New("Parallel lookups did not agree on a successor")
+
+// LookupConfig Redundancy of the lookups a node starts. The zero value
+// follows a single path, as plain Chord does
+type LookupConfig struct {
+	// Parallel number of lookups run at once, each starting from a
+	// different finger or successor. Below 2 one path is followed
+	Parallel int
+	// Quorum paths that must agree on the successor of a parallel
+	// lookup. 1 takes the first answer; zero requires a majority
+	Quorum int
+	// HedgePercentile starts a backup lookup from another finger once a
+	// lookup takes longer than this percentile of recent lookups, e.g.
+	// 0.95. Zero disables hedging. Not used for parallel lookups
+	HedgePercentile float64
+}
+
+// quorum Returns the number of agreeing paths needed out of k
+func (c LookupConfig) quorum(k int) int {
+	switch {
+	case c.Quorum <= 0:
+		return k/2 + 1
+	case c.Quorum > k:
+		return k
+	}
+	return c.Quorum
+}
+
+// lookupCounts Snapshot of the lookups started by a node
+type lookupCounts struct {
+	Lookups int
+	Failed  int
+	// Parallel lookups, those whose paths disagreed on the successor
+	// and those that ended without a quorum
+	Parallel  int
+	Disagreed int
+	NoQuorum  int
+	// Backup lookups started, and how often the backup answered first
+	Hedged   int
+	HedgeWon int
+	// HedgeDelay current delay before a backup is started
+	HedgeDelay string `json:",omitempty"`
+}
+
+// lookupLog Counts lookups and keeps the latencies of recent ones
+type lookupLog struct {
+	sync.Mutex
+	c         lookupCounts
+	latencies []time.Duration
+	next      int
+}
+
+func newLookupLog() *lookupLog {
+	return &lookupLog{latencies: make([]time.Duration, 0, lookupSamples)}
+}
+
+// done Records a finished lookup that took d
+func (l *lookupLog) done(d time.Duration, err error) {
+	l.Lock()
+	defer l.Unlock()
+	l.c.Lookups++
+	if err != nil {
+		l.c.Failed++
+		return
+	}
+	if len(l.latencies) < lookupSamples {
+		l.latencies = append(l.latencies, d)
+		return
+	}
+	l.latencies[l.next] = d
+	l.next = (l.next + 1) % lookupSamples
+}
+
+// percentile Returns the p-th percentile of recent lookup latencies.
+// Reports false until enough lookups have been seen
+func (l *lookupLog) percentile(p float64) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.latencies) < minHedgeSamples {
+		return 0, false
+	}
+	s := append([]time.Duration(nil), l.latencies...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(p * float64(len(s)))
+	if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i], true
+}
+
+func (l *lookupLog) add(f func(c *lookupCounts)) {
+	l.Lock()
+	f(&l.c)
+	l.Unlock()
+}
+
+func (l *lookupLog) counts(hedge float64) lookupCounts {
+	var delay string
+	if hedge > 0 {
+		if d, ok := l.percentile(hedge); ok {
+			delay = d.String()
+		}
+	}
+	l.Lock()
+	defer l.Unlock()
+	c := l.c
+	c.HedgeDelay = delay
+	return c
+}
+
+// lookupResult Outcome of one lookup path
+type lookupResult struct {
+	succ   *comm.Rnode
+	hops   int
+	err    error
+	backup bool
+}
+
+// lookup Finds the successor of id and the number of nodes visited,
+// running redundant paths as configured
+func (n *Node) lookup(ctx context.Context, id util.Identifier) (*comm.Rnode, int, error) {
+	start := n.clock.Now()
+	ctx, span := n.tracer.Start(ctx, "lookup")
+	span.Set("id", hex.EncodeToString(id))
+	var r lookupResult
+	cfg := n.cfg.Lookup
+	switch {
+	case cfg.Parallel > 1:
+		r = n.parallelLookup(ctx, id, n.lookupStarts(id, cfg.Parallel))
+	case cfg.HedgePercentile > 0:
+		r = n.hedgedLookup(ctx, id, n.lookupStarts(id, 2))
+	default:
+		r = n.lookupFrom(ctx, id, n.Rnode)
+	}
+	n.lookups.done(n.clock.Now().Sub(start), r.err)
+	if r.err == nil {
+		n.metrics.lookupHops.Observe(float64(r.hops))
+		span.Set("hops", strconv.Itoa(r.hops))
+	}
+	span.End(r.err)
+	return r.succ, r.hops, r.err
+}
+
+// lookupStarts Returns up to k distinct nodes to start lookups of id
+// from: the fingers closest to id first, then the successors. A plain
+// lookup would visit the first of them next. Returns n alone if no
+// finger precedes id
+func (n *Node) lookupStarts(id util.Identifier, k int) []*comm.Rnode {
+	var starts []*comm.Rnode
+	add := func(rn *comm.Rnode) {
+		if len(starts) == k || rn == nil || !rn.ID.IsBetween(n.ID, id) || n.remote.Suspect(*rn) {
+			return
+		}
+		for _, s := range starts {
+			if s.ID.IsEqual(rn.ID) {
+				return
+			}
+		}
+		starts = append(starts, rn)
+	}
+
+	n.nMu.RLock()
+	for i := KeySize - 1; i >= 0; i-- {
+		add(n.fingers[i].node)
+	}
+	n.nMu.RUnlock()
+	succs := n.successorList()
+	for i := range succs {
+		add(&succs[i])
+	}
+	if len(starts) == 0 {
+		return []*comm.Rnode{n.Rnode}
+	}
+	return starts
+}
+
+// lookupFrom Follows one path from start to the successor of id
+func (n *Node) lookupFrom(ctx context.Context, id util.Identifier, start *comm.Rnode) lookupResult {
+	pre, hops, err := n.lookupPredecessor(ctx, id, start)
+	if err != nil {
+		return lookupResult{hops: hops, err: err}
+	}
+	began := n.clock.Now()
+	succ, err := n.remote.GetSuccessor(ctx, *pre)
+	n.traceHop(ctx, "GetSuccessor", pre, began, err, nil)
+	if err != nil {
+		return lookupResult{hops: hops, err: err}
+	}
+	return lookupResult{succ: succ, hops: hops}
+}
+
+// parallelLookup Runs one path from each start and returns the first
+// successor that a quorum of paths agrees on
+func (n *Node) parallelLookup(ctx context.Context, id util.Identifier, starts []*comm.Rnode) lookupResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan lookupResult, len(starts))
+	for i, s := range starts {
+		go func(path int, s *comm.Rnode) {
+			results <- n.lookupFrom(onPath(ctx, path), id, s)
+		}(i, s)
+	}
+
+	need := n.cfg.Lookup.quorum(len(starts))
+	votes := make(map[string]int)
+	defer n.lookups.add(func(c *lookupCounts) {
+		c.Parallel++
+		if len(votes) > 1 {
+			c.Disagreed++
+		}
+	})
+	var last lookupResult
+	for range starts {
+		r := <-results
+		if r.err != nil {
+			last = r
+			continue
+		}
+		key := string(r.succ.ID)
+		votes[key]++
+		if votes[key] >= need {
+			return r
+		}
+	}
+	if len(votes) == 0 {
+		return last
+	}
+	n.lookups.add(func(c *lookupCounts) { c.NoQuorum++ })
+	return lookupResult{err: ErrNoQuorum}
+}
+
+// hedgedLookup Follows the path from the first start. Once it has taken
+// longer than the configured percentile of recent lookups, or failed,
+// a backup path from the second start is raced against it
+func (n *Node) hedgedLookup(ctx context.Context, id util.Identifier, starts []*comm.Rnode) lookupResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan lookupResult, 2)
+	run := func(s *comm.Rnode, backup bool) {
+		path := 0
+		if backup {
+			path = 1
+		}
+		r := n.lookupFrom(onPath(ctx, path), id, s)
+		r.backup = backup
+		results <- r
+	}
+	go run(starts[0], false)
+
+	var hedge <-chan time.Time
+	if len(starts) > 1 {
+		if d, ok := n.lookups.percentile(n.cfg.Lookup.HedgePercentile); ok {
+			hedge = n.clock.After(d)
+		}
+	}
+	startBackup := func() {
+		hedge = nil
+		n.lookups.add(func(c *lookupCounts) { c.Hedged++ })
+		go run(starts[1], true)
+	}
+
+	pending := 1
+	for {
+		select {
+		case <-hedge:
+			pending++
+			startBackup()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.backup {
+					n.lookups.add(func(c *lookupCounts) { c.HedgeWon++ })
+				}
+				return r
+			}
+			// Do not wait for the hedge delay once the primary path failed
+			if hedge != nil {
+				pending++
+				startBackup()
+			}
+			if pending == 0 {
+				return r
+			}
+		}
+	}
+}
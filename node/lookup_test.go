@@ -0,0 +1,137 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/util"
+)
+
+// stallAPI Holds up the next GetSuccessor call once armed
+type stallAPI struct {
+	comm.NodeComm
+	armed int32
+}
+
+func (s *stallAPI) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
+	if atomic.CompareAndSwapInt32(&s.armed, 1, 0) {
+		time.Sleep(time.Second)
+	}
+	return s.NodeComm.GetSuccessor(args, reply)
+}
+
+type stallTransport struct {
+	netutils.Transport
+	api *stallAPI
+}
+
+func (t stallTransport) Listen(addr string, api comm.NodeComm) (netutils.Listener, error) {
+	t.api.NodeComm = api
+	return t.Transport.Listen(addr, t.api)
+}
+
+// lookupRing Joins k manually driven nodes on an in-memory network.
+// Nodes are sorted by identifier
+func lookupRing(t *testing.T, k int, lookup LookupConfig) ([]*Node, map[string]*stallAPI) {
+	network := netutils.NewMemNetwork(1)
+	apis := make(map[string]*stallAPI)
+	var nodes []*Node
+	for i := 0; i < k; i++ {
+		api := &stallAPI{}
+		n, err := New(Config{
+			RPCAddr:   "127.0.0.1:0",
+			Transport: stallTransport{network.Transport(), api},
+			Lookup:    lookup,
+			Manual:    true,
+			Log:       DiscardLogger(),
+			Seed:      int64(i + 1),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		bootstrap := ""
+		if i > 0 {
+			bootstrap = nodes[0].IP
+		}
+		if err := n.Join(bootstrap); err != nil {
+			t.Fatal(err)
+		}
+		apis[n.IP] = api
+		nodes = append(nodes, n)
+	}
+	for r := 0; r < 50; r++ {
+		for _, n := range nodes {
+			n.Stabilize()
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return bytes.Compare(nodes[i].ID, nodes[j].ID) < 0 })
+	return nodes, apis
+}
+
+// owner Returns the node responsible for id
+func owner(nodes []*Node, id util.Identifier) *Node {
+	for _, n := range nodes {
+		if bytes.Compare(id, n.ID) <= 0 {
+			return n
+		}
+	}
+	return nodes[0]
+}
+
+func TestParallelLookup(t *testing.T) {
+	nodes, _ := lookupRing(t, 6, LookupConfig{Parallel: 3})
+	for _, n := range nodes {
+		for i := 0; i < 20; i++ {
+			id := util.StringToID(util.HashValue(string(rune('a' + i))))
+			succ, _, err := n.Lookup(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := owner(nodes, id); !succ.ID.IsEqual(want.ID) {
+				t.Fatalf("%s looked up %s, want %s", n.IP, succ.IP, want.IP)
+			}
+		}
+		if c := n.lookups.counts(0); c.Parallel == 0 || c.NoQuorum != 0 {
+			t.Fatalf("%s: %+v", n.IP, c)
+		}
+	}
+}
+
+func TestHedgedLookup(t *testing.T) {
+	nodes, apis := lookupRing(t, 5, LookupConfig{HedgePercentile: 0.5})
+	n, target := nodes[0], nodes[len(nodes)-2].ID
+	for i := 0; i < minHedgeSamples; i++ {
+		n.lookups.done(time.Millisecond*20, nil)
+	}
+
+	// The path the lookup takes first stalls; the backup must answer
+	starts := n.lookupStarts(target, 2)
+	if len(starts) < 2 {
+		t.Fatalf("need two starts, got %d", len(starts))
+	}
+	atomic.StoreInt32(&apis[starts[0].IP].armed, 1)
+	began := time.Now()
+	succ, _, err := n.Lookup(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !succ.ID.IsEqual(target) {
+		t.Fatalf("looked up %s", succ.IP)
+	}
+	if d := time.Since(began); d >= time.Second {
+		t.Fatalf("lookup waited for the stalled path: %s", d)
+	}
+	if c := n.lookups.counts(0); c.Hedged != 1 || c.HedgeWon != 1 {
+		t.Fatalf("%+v", c)
+	}
+}
@@ -1,8 +1,11 @@
 package node
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,30 +16,100 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/hoffa2/chord/comm"
 	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/tracing"
 	"github.com/hoffa2/chord/util"
 	"github.com/urfave/cli"
 )
 
-type Logger struct {
-	Err  *log.Logger
-	Info *log.Logger
+// LoadTLSFlags Loads the mutual TLS configuration given by the
+// --tls-cert, --tls-key and --tls-ca flags. Nil if none is set
+func LoadTLSFlags(c *cli.Context) (*tls.Config, error) {
+	if !c.IsSet("tls-cert") && !c.IsSet("tls-key") && !c.IsSet("tls-ca") {
+		return nil, nil
+	}
+	return netutils.LoadTLS(netutils.TLSFiles{
+		CertFile: c.String("tls-cert"),
+		KeyFile:  c.String("tls-key"),
+		CAFile:   c.String("tls-ca"),
+	})
 }
 
 // Run Runs a chord node
 func Run(c *cli.Context) error {
 	port := c.String("port")
 	if port == "" {
-		port = "8030"
+		port = netutils.DefaultHTTPPort
+	}
+	rpcPort := c.String("rpcport")
+	if rpcPort == "" {
+		rpcPort = netutils.DefaultRPCPort
 	}
 
-	NameServerAddr := c.String("nameserver")
-	graph := c.Int("graph")
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
 
-	r := mux.NewRouter()
-	n, err := os.Hostname()
+	cfg := Config{
+		RPCAddr:       ":" + rpcPort,
+		HTTPAddr:      ":" + port,
+		NameServer:    c.String("nameserver"),
+		Successors:    c.Int("successors"),
+		PhiThreshold:  netutils.DefaultPhiThreshold,
+		Graph:         c.Int("graph") != 0,
+		Ring:          c.String("ring"),
+		ClusterSecret: c.String("secret"),
+		StrictIDs:     c.Bool("strict-ids"),
+		Lookup: LookupConfig{
+			Parallel:        c.Int("lookup-parallel"),
+			Quorum:          c.Int("lookup-quorum"),
+			HedgePercentile: c.Float64("hedge"),
+		},
+	}
+	if cfg.Pool.Codec, err = netutils.ParseCodec(c.String("codec")); err != nil {
+		return err
+	}
+	if cfg.TLS, err = LoadTLSFlags(c); err != nil {
+		return err
+	}
+	if c.IsSet("auth") {
+		cfg.Auth, err = LoadAuth(c.String("auth"), nil)
+		if err != nil {
+			return err
+		}
+	}
+	if c.IsSet("probe") {
+		cfg.ProbeInterval = c.Duration("probe")
+	}
+	if c.IsSet("phi") {
+		cfg.PhiThreshold = c.Float64("phi")
+	}
+	if c.IsSet("trace-file") {
+		exp, err := tracing.NewFileExporter(c.String("trace-file"), c.String("trace-format"))
+		if err != nil {
+			return err
+		}
+		defer exp.Close()
+		cfg.Trace = exp
+	}
+
+	// Address other nodes dial; the ID is derived from it unless given
+	cfg.Advertise = c.String("advertise")
+	if cfg.Advertise == "" {
+		cfg.Advertise = net.JoinHostPort(hostname, rpcPort)
+	}
+	// Clients reach the HTTP API on the advertised host
+	host, _, err := net.SplitHostPort(cfg.Advertise)
 	if err != nil {
 		return err
 	}
+	cfg.AdvertiseHTTP = net.JoinHostPort(host, port)
+	if c.IsSet("id") {
+		cfg.ID, err = util.HexToID(c.String("id"), KeySize/8)
+		if err != nil {
+			return err
+		}
+	}
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	http.DefaultTransport.(*http.Transport).IdleConnTimeout = time.Second * 2
@@ -47,39 +120,20 @@ func Run(c *cli.Context) error {
 	signal.Notify(ch, os.Interrupt, syscall.SIGKILL)
 	signal.Notify(ch, os.Interrupt, syscall.SIGINT)
 
-	infolog := log.New(os.Stdout, "\x1b[32m"+n+"\x1b[0m"+" --> ", log.Lshortfile)
-	errlog := log.New(os.Stderr, "\x1b[31m"+n+"\x1b[0m"+" --> ", log.Lshortfile)
-
-	client := http.Client{
-		Timeout: time.Duration(time.Second * 3),
-	}
-	node := &Node{
-		nameServer: NameServerAddr,
-		Rnode: &comm.Rnode{
-			IP: n,
-			ID: util.StringToID(util.HashValue(n)),
-		},
-		objectStore: make(map[string]string),
-		conn:        client,
-		fingers:     make([]FingerEntry, KeySize),
-		log:         &Logger{Err: errlog, Info: infolog},
-		exitChan:    make(chan string),
-		graphIP:     "129.242.22.74:8080",
-		graph:       graph != 0,
+	node, err := New(cfg)
+	if err != nil {
+		return err
 	}
-
-	node.remote = netutils.NewRemote(node.failhandler)
-	l, err := netutils.SetupRPCServer("8011", node)
+	err = node.Start(context.Background())
 	if err != nil {
 		return err
 	}
-
-	defer l.Close()
+	defer node.Close()
 
 	// CTRL-C
 	go func() {
 		<-ch
-		l.Close()
+		node.Close()
 		fmt.Println("KILLED")
 		os.Exit(1)
 	}()
@@ -89,50 +143,245 @@ func Run(c *cli.Context) error {
 		if r := recover(); r != nil {
 			fmt.Println("Recovered: ", r)
 		}
-		l.Close()
+		node.Close()
 		os.Exit(1)
 	}()
 
-	err = JoinNetwork(node, n)
+	err = node.registerNode()
+	if err != nil {
+		return err
+	}
+	nodes, err := netutils.GetNodeIPs(node.nameServer)
+	if err != nil {
+		return err
+	}
+	err = node.Join(node.getRandomNode(nodes))
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	// Registering the put and get methods
-	r.HandleFunc("/{key}", node.getKey).Methods("GET")
-	r.HandleFunc("/{key}", node.putKey).Methods("PUT")
-	r.HandleFunc("/state/get", node.state).Methods("GET")
+	// Closed once the node has been told to leave the network
+	<-node.Done()
+	node.log.Err.Println("AFTER EXIT!!!")
+	os.Exit(0)
+
+	// Assertion
+	panic("Reached end")
+}
 
-	// Used in sending errors from httplisten
-	errchan := make(chan error)
+// Start starts serving RPC and, if configured, the HTTP API.
+// The node is not part of any ring until Join is called.
+// Cancelling ctx closes the node
+func (n *Node) Start(ctx context.Context) error {
+	l, err := n.cfg.Transport.Listen(n.cfg.RPCAddr, n.API())
+	if err != nil {
+		return err
+	}
+	n.listener = l
 
-	srv := &http.Server{
-		Addr:         ":" + port,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		Handler:      r,
+	if n.IP == "" {
+		n.IP = l.Addr()
 	}
-	go func() {
-		err := srv.ListenAndServe()
+	n.tracer.SetNode(n.IP)
+	if n.ID == nil {
+		n.ID = util.StringToID(util.HashValue(n.IP))
+	} else if n.cfg.StrictIDs && !ownsID(*n.Rnode) {
+		l.Close()
+		return ErrUntrustedID
+	}
+
+	if n.cfg.HTTPAddr != "" {
+		hl, err := net.Listen("tcp4", n.cfg.HTTPAddr)
 		if err != nil {
-			errchan <- err
+			l.Close()
+			return err
+		}
+		if n.cfg.TLS != nil {
+			// Clients of the API need not hold a cluster certificate
+			conf := n.cfg.TLS.Clone()
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+			hl = tls.NewListener(hl, conf)
+		}
+		n.httpAddr = n.cfg.AdvertiseHTTP
+		if n.httpAddr == "" {
+			host, _, _ := net.SplitHostPort(n.IP)
+			_, port, _ := net.SplitHostPort(hl.Addr().String())
+			n.httpAddr = net.JoinHostPort(host, port)
+		}
+		n.srv = &http.Server{
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: requestTimeout,
+			Handler:      n.instrument(n.traceRequests(n.router())),
+		}
+		go func() {
+			err := n.srv.Serve(hl)
+			if err != nil && err != http.ErrServerClosed {
+				n.log.Err.Println(err)
+			}
+		}()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Close()
+		case <-n.quit:
 		}
 	}()
+	return nil
+}
 
-	select {
-	case err := <-errchan:
-		l.Close()
+// Registering the put and get methods
+func (n *Node) router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/state/get", n.authorize(RightAdmin, n.state)).Methods("GET")
+	r.HandleFunc("/metrics", n.authorize(RightAdmin, n.metrics.registry.ServeHTTP)).Methods("GET")
+	r.HandleFunc("/admin/leave", n.authorize(RightAdmin, n.adminLeave)).Methods("POST")
+	r.HandleFunc("/admin/routing", n.authorize(RightAdmin, n.routing)).Methods("GET")
+	r.HandleFunc("/_lookup/{key}", n.authorize(RightRead, n.lookupKey)).Methods("GET")
+	r.HandleFunc("/{key}", n.authorize(RightRead, n.getKey)).Methods("GET")
+	r.HandleFunc("/{key}", n.authorize(RightWrite, n.putKey)).Methods("PUT")
+	return r
+}
+
+// adminLeave makes n leave the ring and shut down once the response is sent
+func (n *Node) adminLeave(w http.ResponseWriter, r *http.Request) {
+	n.log.Info.Printf("Leaving on request of %s\n", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+	go func() {
+		n.Leave()
+		n.Close()
+	}()
+}
+
+// Join enters the ring known by the node at bootstrap.
+// An empty bootstrap creates a new ring
+func (n *Node) Join(bootstrap string) error {
+	defer func() {
+		if n.graph {
+			n.addNodeToGraph()
+			go n.pushState()
+		}
+	}()
+
+	if bootstrap == "" || bootstrap == n.IP {
+		n.initFTable(true)
+		n.setSuccessor(n.Rnode)
+		n.setPredecessor(n.Rnode)
+		n.runStabilize()
+		return nil
+	}
+
+	n.initFTable(false)
+	n.peers.add(bootstrap)
+	succ, err := n.remote.FindSuccessor(n.ctx, comm.Rnode{IP: bootstrap}, n.ID)
+	if err != nil {
 		return err
-	// Used to make a node leave the network
-	case <-node.exitChan:
-		node.leave()
-		l.Close()
-		node.log.Err.Println("GOT LEAVE MESSAGE!!!")
-		node.log.Err.Println("AFTER EXIT!!!")
-		os.Exit(0)
+	}
+	if !n.trusted(*succ, "Join") {
+		return ErrUntrustedID
+	}
+
+	n.setSuccessor(succ)
+	n.setPredecessor(n.Rnode)
+	n.runStabilize()
+	return nil
+}
 
+// Leave leaves the ring gracefully. Keys are handed to the successor,
+// and predecessor and successor are linked to each other
+func (n *Node) Leave() error {
+	if n.graph {
+		n.leave()
 	}
-	// Assertion
-	panic("Reached end")
+
+	succ := *n.successor()
+	pred := *n.predecessor()
+	if succ.ID.IsEqual(n.ID) {
+		return nil
+	}
+
+	n.mu.Lock()
+	keys := n.objectStore
+	n.objectStore = make(map[string]string)
+	n.mu.Unlock()
+	for k, v := range keys {
+		err := n.remote.PutRemote(n.ctx, succ, k, v)
+		if err != nil {
+			return err
+		}
+		n.metrics.migrated.Add(float64(len(k) + len(v)))
+	}
+
+	err := n.remote.UpdatePredecessor(n.ctx, succ, pred.ID, pred.IP)
+	if err != nil {
+		return err
+	}
+	if !pred.ID.IsEqual(n.ID) {
+		return n.remote.UpdateSuccessor(n.ctx, pred, succ.ID, succ.IP)
+	}
+	return nil
+}
+
+// Stabilize runs one round of the stabilize routine.
+// Meant for nodes configured as Manual; must not be called concurrently
+func (n *Node) Stabilize() {
+	n.stabilize()
+}
+
+// Lookup finds the successor of id and reports the number of
+// nodes the lookup visited
+func (n *Node) Lookup(ctx context.Context, id util.Identifier) (comm.Rnode, int, error) {
+	succ, hops, err := n.lookup(ctx, id)
+	if err != nil {
+		return comm.Rnode{}, hops, err
+	}
+	return *succ, hops, nil
+}
+
+// Close stops all routines and closes the node's listeners and connections
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.quit)
+		n.cancel()
+		if n.listener != nil {
+			n.listener.Close()
+		}
+		if n.srv != nil {
+			n.srv.Close()
+		}
+		n.remote.Close()
+	})
+	return nil
+}
+
+// Done is closed when the node shuts down
+func (n *Node) Done() <-chan struct{} {
+	return n.quit
+}
+
+// API Returns the RPC interface other nodes call
+func (n *Node) API() comm.NodeComm {
+	return &rpcServer{n}
+}
+
+// Successor Returns the node's current successor
+func (n *Node) Successor() comm.Rnode {
+	return *n.successor()
+}
+
+// Predecessor Returns the node's current predecessor
+func (n *Node) Predecessor() comm.Rnode {
+	return *n.predecessor()
+}
+
+// SuccessorList Returns a copy of the node's successor list
+func (n *Node) SuccessorList() []comm.Rnode {
+	return n.successorList()
+}
+
+// HTTPAddr Returns the address clients reach the key-value API on
+func (n *Node) HTTPAddr() string {
+	return n.httpAddr
 }
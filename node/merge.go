@@ -0,0 +1,168 @@
+package node
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+)
+
+const (
+	// DefaultProbeInterval how often a node checks that a known peer is on its ring
+	DefaultProbeInterval = time.Second * 10
+	// knownPeers addresses remembered as probe targets
+	knownPeers = 32
+	// maxMergeSteps successor pointers one probe may rewire
+	maxMergeSteps = 32
+)
+
+// peerSet Addresses of nodes seen in the past. Peers are kept when they
+// fail, as a partitioned ring only finds its other half through them.
+// The oldest address is replaced once the set is full
+type peerSet struct {
+	sync.Mutex
+	addrs []string
+	next  int
+}
+
+func (p *peerSet) add(addr string) {
+	if addr == "" {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, a := range p.addrs {
+		if a == addr {
+			return
+		}
+	}
+	if len(p.addrs) < knownPeers {
+		p.addrs = append(p.addrs, addr)
+		return
+	}
+	p.addrs[p.next] = addr
+	p.next = (p.next + 1) % knownPeers
+}
+
+// pick Returns a random address other than self
+func (p *peerSet) pick(r *rand.Rand, self string) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	var addrs []string
+	for _, a := range p.addrs {
+		if a != self {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return "", false
+	}
+	return addrs[r.Intn(len(addrs))], true
+}
+
+// mergeCounts Snapshot of ring probes and merges
+type mergeCounts struct {
+	Probes int
+	// Diverged probes that found a node missing from this ring
+	Diverged int
+	// Moved successor pointers rewired while merging
+	Moved  int
+	Errors int
+}
+
+type mergeLog struct {
+	sync.Mutex
+	c mergeCounts
+}
+
+func (m *mergeLog) add(f func(c *mergeCounts)) {
+	m.Lock()
+	f(&m.c)
+	m.Unlock()
+}
+
+func (m *mergeLog) counts() mergeCounts {
+	m.Lock()
+	defer m.Unlock()
+	return m.c
+}
+
+// probeDue reports whether the next stabilize round should probe.
+// Only called from the stabilize routine
+func (n *Node) probeDue() bool {
+	if n.cfg.ProbeInterval < 0 {
+		return false
+	}
+	now := n.clock.Now()
+	if !n.lastProbe.IsZero() && now.Sub(n.lastProbe) < n.cfg.ProbeInterval {
+		return false
+	}
+	n.lastProbe = now
+	return true
+}
+
+// probe Asks a random known peer for the successor of n. A peer on the
+// same ring answers with n or n's successor. Any other answer is a node
+// the ring of n lacks, either because the network was partitioned and
+// two rings formed, or because the ring wraps around the identifier
+// space more than once; it is merged into the ring of n
+func (n *Node) probe() {
+	if n.nameServer != "" {
+		if addrs, err := netutils.GetNodeIPs(n.nameServer); err == nil {
+			for _, addr := range addrs {
+				n.peers.add(addr)
+			}
+		}
+	}
+	addr, ok := n.peers.pick(n.rand, n.IP)
+	if !ok {
+		return
+	}
+	cand, err := n.remote.FindSuccessor(n.ctx, comm.Rnode{IP: addr}, n.ID)
+	if err != nil {
+		return
+	}
+	n.merges.add(func(c *mergeCounts) { c.Probes++ })
+	if cand.ID.IsEqual(n.ID) || cand.ID.IsEqual(n.successor().ID) || !n.trusted(*cand, "probe") {
+		return
+	}
+	n.merge(*cand)
+}
+
+// merge Links cand in behind its predecessor on the ring of n. The
+// successor it displaces may itself be missing from the ring cand came
+// from, so it is merged next, until a node is found in place. Stabilize
+// and notify then repair predecessors and successor lists
+func (n *Node) merge(cand comm.Rnode) {
+	for i := 0; i < maxMergeSteps; i++ {
+		pre, _, err := n.lookupPredecessor(n.ctx, cand.ID, n.Rnode)
+		if err != nil {
+			n.merges.add(func(c *mergeCounts) { c.Errors++ })
+			return
+		}
+		succ, err := n.remote.GetSuccessor(n.ctx, *pre)
+		if err != nil {
+			n.merges.add(func(c *mergeCounts) { c.Errors++ })
+			return
+		}
+		if succ.ID.IsEqual(cand.ID) || !cand.ID.IsBetween(pre.ID, succ.ID) {
+			return
+		}
+		if i == 0 {
+			n.merges.add(func(c *mergeCounts) { c.Diverged++ })
+			n.log.Info.Printf("Merging %s, missing between %s and %s\n", cand.IP, pre.IP, succ.IP)
+		}
+
+		if pre.ID.IsEqual(n.ID) {
+			n.setSuccessor(&cand)
+		} else if err := n.remote.UpdateSuccessor(n.ctx, *pre, cand.ID, cand.IP); err != nil {
+			n.merges.add(func(c *mergeCounts) { c.Errors++ })
+			return
+		}
+		n.remote.Notify(n.ctx, cand, pre)
+		n.merges.add(func(c *mergeCounts) { c.Moved++ })
+		cand = *succ
+	}
+}
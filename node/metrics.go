@@ -0,0 +1,113 @@
+package node
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoffa2/chord/metrics"
+)
+
+// nodeMetrics Series a node serves at /metrics
+type nodeMetrics struct {
+	registry     *metrics.Registry
+	httpRequests *metrics.Counter
+	httpDuration *metrics.Histogram
+	lookupHops   *metrics.Histogram
+	rpcCalls     *metrics.Counter
+	rpcErrors    *metrics.Counter
+	rpcDuration  *metrics.Histogram
+	stabilize    *metrics.Histogram
+	migrated     *metrics.Counter
+	// Nodes do not replicate keys yet; the series is exported as
+	// requested and stays at zero until they do
+	replicated *metrics.Counter
+}
+
+func newNodeMetrics(n *Node) *nodeMetrics {
+	r := metrics.NewRegistry()
+	m := &nodeMetrics{
+		registry: r,
+		httpRequests: r.Counter("chord_http_requests_total",
+			"HTTP requests served, by method and status code", "method", "code"),
+		httpDuration: r.Histogram("chord_http_request_duration_seconds",
+			"Time taken to serve HTTP requests", metrics.DurationBuckets, "method", "code"),
+		lookupHops: r.Histogram("chord_lookup_hops",
+			"Nodes visited by successful lookups", metrics.CountBuckets),
+		rpcCalls: r.Counter("chord_rpc_calls_total",
+			"RPC calls made to peers, by NodeComm method and peer", "method", "peer"),
+		rpcErrors: r.Counter("chord_rpc_errors_total",
+			"RPC calls to peers that failed, by NodeComm method and peer", "method", "peer"),
+		rpcDuration: r.Histogram("chord_rpc_duration_seconds",
+			"Time taken by RPC calls to peers", metrics.DurationBuckets, "method"),
+		stabilize: r.Histogram("chord_stabilize_duration_seconds",
+			"Time taken by one round of the stabilize routine", metrics.DurationBuckets),
+		migrated: r.Counter("chord_migrated_bytes_total",
+			"Bytes of keys and values handed to other nodes as key ranges moved"),
+		replicated: r.Counter("chord_replicated_bytes_total",
+			"Bytes of keys and values copied to replicas; always 0 until nodes replicate keys"),
+	}
+	r.Gauge("chord_successors", "Length of the successor list", func() float64 {
+		return float64(len(n.successorList()))
+	})
+	r.Gauge("chord_keys", "Keys stored on the node", func() float64 {
+		keys, _ := n.storeSize()
+		return float64(keys)
+	})
+	r.Gauge("chord_store_bytes", "Bytes taken by the keys and values stored on the node", func() float64 {
+		_, bytes := n.storeSize()
+		return float64(bytes)
+	})
+	return m
+}
+
+// rpc Records a call to peer
+func (m *nodeMetrics) rpc(method, peer string, took time.Duration, err error) {
+	method = strings.TrimPrefix(method, "NodeComm.")
+	m.rpcCalls.Inc(method, peer)
+	if err != nil {
+		m.rpcErrors.Inc(method, peer)
+	}
+	m.rpcDuration.Observe(took.Seconds(), method)
+}
+
+// statusWriter Remembers the status code written
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush Passes flushes on to the wrapped writer, if it supports them
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts and times the requests served by h
+func (n *Node) instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		began := n.clock.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		code := strconv.Itoa(sw.code)
+		n.metrics.httpRequests.Inc(r.Method, code)
+		n.metrics.httpDuration.Observe(n.clock.Now().Sub(began).Seconds(), r.Method, code)
+	})
+}
+
+// storeSize Returns the number of keys stored and the bytes they take
+func (n *Node) storeSize() (keys, bytes int) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for k, v := range n.objectStore {
+		bytes += len(k) + len(v)
+	}
+	return len(n.objectStore), bytes
+}
@@ -2,13 +2,13 @@ package node
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,13 +23,13 @@ func readKey(r *http.Request) string {
 }
 
 // Locates the successor of k
-func (n Node) findKeySuccessor(k util.Identifier) (*comm.Rnode, error) {
+func (n *Node) findKeySuccessor(ctx context.Context, k util.Identifier) (*comm.Rnode, error) {
 	// I'm the successor
-	if k.InKeySpace(n.prev.ID, n.ID) {
+	if k.InKeySpace(n.predecessor().ID, n.ID) {
 		return n.Rnode, nil
 	}
 	// TODO: Maybe we should check whether the key is in our successor's keyspace
-	s, err := n.findSuccessor(k)
+	s, err := n.findSuccessor(ctx, k)
 	if err != nil {
 		n.log.Err.Printf("Unable to locate successor on key: %s", err.Error())
 		return nil, err
@@ -37,15 +37,13 @@ func (n Node) findKeySuccessor(k util.Identifier) (*comm.Rnode, error) {
 	return s, nil
 }
 
-// register with nameserver
-func (n Node) registerNode() error {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return err
+// register the advertised address with nameserver
+func (n *Node) registerNode() error {
+	form := url.Values{"ip": {n.IP}, "http": {n.httpAddr}, "id": {hex.EncodeToString(n.ID)}}
+	if n.cfg.ClusterSecret != "" {
+		form.Set("proof", netutils.JoinProof(n.cfg.ClusterSecret, n.ID, n.IP))
 	}
-
-	resp, err := n.conn.PostForm(fmt.Sprintf("http://%s/", n.nameServer),
-		url.Values{"ip": {hostname}})
+	resp, err := n.conn.PostForm(fmt.Sprintf("http://%s/", n.nameServer), form)
 	if err != nil {
 		return err
 	}
@@ -64,73 +62,92 @@ func (n *Node) setPredecessor(rn *comm.Rnode) error {
 	return nil
 }
 
-// Set successor locked
+// Set successor locked. The successor is prepended to the successor list
 func (n *Node) setSuccessor(rn *comm.Rnode) error {
 	n.nMu.Lock()
-	n.fingers[0].node = rn
-	if !rn.ID.IsEqual(n.ID) {
-		n.successors = append([]comm.Rnode{*rn}, n.successors...)
-	}
-	n.nMu.Unlock()
+	defer n.nMu.Unlock()
+	n.successors = n.buildSuccessors(*rn, n.successors)
+	n.setFirstFinger(*rn)
 	return nil
 }
 
-func (n Node) getRandomNode(nodes []string) string {
-	for _, node := range nodes {
-		if node != n.IP {
-			return node
-		}
+// Replaces the successor list with succ followed by succ's own list
+func (n *Node) setSuccessorList(succ comm.Rnode, list []comm.Rnode) {
+	n.peers.add(succ.IP)
+	for _, s := range list {
+		n.peers.add(s.IP)
 	}
-	return ""
+	n.nMu.Lock()
+	defer n.nMu.Unlock()
+	n.successors = n.buildSuccessors(succ, list)
+	n.setFirstFinger(succ)
 }
 
-// JoinNetwork registers itself in the network by asking a random
-// node found by issuing a request to the nameserver
-func JoinNetwork(n *Node, id string) error {
-	err := n.registerNode()
-	if err != nil {
-		return err
+// Builds a successor list as per Chord: head followed by rest,
+// truncated to r entries. Since the list is ordered along the ring
+// it ends as soon as it wraps around to n. Expects nMu to be held
+func (n *Node) buildSuccessors(head comm.Rnode, rest []comm.Rnode) []comm.Rnode {
+	succs := make([]comm.Rnode, 0, n.nSuccessors)
+	for _, s := range append([]comm.Rnode{head}, rest...) {
+		if len(succs) == n.nSuccessors || s.ID.IsEqual(n.ID) {
+			break
+		}
+		dup := false
+		for _, t := range succs {
+			if t.ID.IsEqual(s.ID) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			succs = append(succs, s)
+		}
 	}
+	return succs
+}
 
-	nodes, err := netutils.GetNodeIPs(n.nameServer)
-	if err != nil {
-		return err
+// Points the first finger at rn. Expects nMu to be held
+func (n *Node) setFirstFinger(rn comm.Rnode) {
+	if rn.ID.IsEqual(n.ID) {
+		n.fingers[0].node = n.Rnode
+		return
 	}
+	n.fingers[0].node = &rn
+}
 
-	defer func() {
-		if n.graph {
-			n.addNodeToGraph()
-			go n.pushState()
-		}
-	}()
-
-	if len(nodes) == 1 && nodes[0] == n.IP {
-		n.setSuccessor(n.Rnode)
-		n.setPredecessor(n.Rnode)
-		n.initFTable(true)
-		go n.periodicRun()
-		return nil
-	}
+// Get a copy of the successor list locked
+func (n *Node) successorList() []comm.Rnode {
+	n.nMu.RLock()
+	defer n.nMu.RUnlock()
+	return append([]comm.Rnode(nil), n.successors...)
+}
 
-	n.initFTable(false)
+// Get successor locked
+func (n *Node) successor() *comm.Rnode {
+	n.nMu.RLock()
+	defer n.nMu.RUnlock()
+	return n.fingers[0].node
+}
 
-	node := n.getRandomNode(nodes)
+// Get predecessor locked
+func (n *Node) predecessor() *comm.Rnode {
+	n.nMu.RLock()
+	defer n.nMu.RUnlock()
+	return n.prev
+}
 
-	rnode := &comm.Rnode{IP: node}
-	succ, err := n.remote.FindSuccessor(*rnode, n.ID)
-	if err != nil {
-		return err
+func (n *Node) getRandomNode(nodes []string) string {
+	for _, node := range nodes {
+		if node != n.IP {
+			return node
+		}
 	}
-
-	n.setSuccessor(succ)
-	n.setPredecessor(n.Rnode)
-	go n.periodicRun()
-	return nil
+	return ""
 }
 
 // Get keys in my identifier space NOT USED
 func (n *Node) retrieveKeys() error {
-	keys, err := n.remote.GetKeysInInterval(*n.prev, n.prev.ID, n.ID)
+	keys, err := n.remote.GetKeysInInterval(n.ctx, *n.prev, n.prev.ID, n.ID)
 	if err != nil {
 		return err
 	}
@@ -157,7 +174,7 @@ func (n *Node) initFTable(alone bool) {
 // Sending state through ssh NOT USED
 func (n *Node) reportState() {
 	for {
-		time.Sleep(time.Second * 5)
+		n.clock.Sleep(time.Second * 5)
 		log.Printf("State (%s): (%s:%s)\n", n.IP, n.prev.IP, n.fingers[0].node.IP)
 	}
 }
@@ -169,92 +186,137 @@ func (n *Node) state(w http.ResponseWriter, r *http.Request) {
 		Next       string
 		Prev       string
 		Successors []comm.Rnode
+		Failures   failureCounts
+		Admissions admissionCounts
+		Blacklist  blacklistCounts
+		Lookups    lookupCounts
+		Merges     mergeCounts
+		Breakers   netutils.BreakerStats
+		Pool       *netutils.PoolStats `json:",omitempty"`
 	}{
-		n.IP,
-		n.fingers[0].node.IP,
-		n.prev.IP,
-		n.successors,
+		IP:         n.IP,
+		Next:       n.successor().IP,
+		Prev:       n.predecessor().IP,
+		Successors: n.successorList(),
+		Failures:   n.failures.counts(),
+		Admissions: n.admissions.counts(),
+		Blacklist:  n.blacklist.counts(n.clock.Now()),
+		Lookups:    n.lookups.counts(n.cfg.Lookup.HedgePercentile),
+		Merges:     n.merges.counts(),
+		Breakers:   n.remote.BreakerStats(),
+	}
+	if t, ok := n.cfg.Transport.(*netutils.RPCTransport); ok {
+		stats := t.Stats()
+		p.Pool = &stats
 	}
 	util.WriteJson(w, p)
 }
 
-func (n *Node) findPredecessor(id util.Identifier) (*comm.Rnode, error) {
-	var tnode *comm.Rnode
+func (n *Node) findPredecessor(ctx context.Context, id util.Identifier) (*comm.Rnode, error) {
+	pre, _, err := n.lookupPredecessor(ctx, id, n.Rnode)
+	return pre, err
+}
+
+// Walks the ring from start towards id's predecessor. Also returns the
+// number of remote nodes queried on the way. Gives up once ctx is done
+func (n *Node) lookupPredecessor(ctx context.Context, id util.Identifier, start *comm.Rnode) (*comm.Rnode, int, error) {
 	var succ *comm.Rnode
 	var err error
 
-	tnode = n.Rnode
-	succ = n.fingers[0].node
-
-	if id.InKeySpace(tnode.ID, succ.ID) {
-		return tnode, nil
-	}
-
-	// Checking if n is id's predecessor
-	for !id.InKeySpace(tnode.ID, succ.ID) {
-
+	hops := 0
+	tnode := start
+	for i := 0; i < maxLookupHops; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, hops, err
+		}
 		if tnode.ID.IsEqual(n.ID) {
-			tnode = n.closestPreFinger(id)
+			succ = n.successor()
 		} else {
-			tnode, err = n.remote.ClosestPreFinger(*tnode, id)
-			if err == netutils.ErrTimeout {
-				tnode = n.skipClosestFinger(tnode, id)
-			} else if err != nil {
-				return nil, err
+			hops++
+			began := n.clock.Now()
+			succ, err = n.remote.GetSuccessor(ctx, *tnode)
+			// Route around peers that cannot be reached
+			if netutils.PeerFailure(err) {
+				skip := n.skipClosestFinger(tnode, id)
+				n.traceHop(ctx, "GetSuccessor", tnode, began, err, skip)
+				tnode = skip
+				continue
 			}
+			n.traceHop(ctx, "GetSuccessor", tnode, began, err, nil)
+			if err != nil {
+				return nil, hops, err
+			}
+		}
+
+		// Checking if tnode is id's predecessor. A node that is its own
+		// successor has not been linked into a ring yet and owns all of it
+		if succ.ID.IsEqual(tnode.ID) || id.InKeySpace(tnode.ID, succ.ID) {
+			return tnode, hops, nil
 		}
+
+		prev := tnode
 		if tnode.ID.IsEqual(n.ID) {
-			succ = n.fingers[0].node
+			tnode = n.closestPreFinger(id)
 		} else {
-			succ, err = n.remote.GetSuccessor(*tnode)
-			if err == netutils.ErrTimeout {
-				tnode = n.skipClosestFinger(tnode, id)
-			} else if err != nil {
-				return nil, err
+			began := n.clock.Now()
+			next, err := n.remote.ClosestPreFinger(ctx, *tnode, id)
+			if netutils.PeerFailure(err) {
+				next = n.skipClosestFinger(tnode, id)
+				n.traceHop(ctx, "ClosestPreFinger", tnode, began, err, next)
+			} else {
+				n.traceHop(ctx, "ClosestPreFinger", tnode, began, err, nil)
+				if err != nil {
+					return nil, hops, err
+				}
 			}
+			tnode = next
+		}
+		// Without a finger closer to id, tnode's successor is still closer
+		if tnode.ID.IsEqual(prev.ID) {
+			tnode = succ
 		}
 	}
-	return tnode, nil
+	return nil, hops, ErrLookupFailed
 }
 
-// Skips a node if it has failed
+// Skips a node if it has failed. Picks the first successor after rn
+// that the failure detector does not suspect
 func (n *Node) skipClosestFinger(rn *comm.Rnode, id util.Identifier) *comm.Rnode {
-	var cf *comm.Rnode
-	for i, succ := range n.successors {
+	succs := n.successorList()
+	if len(succs) == 0 {
+		return n.successor()
+	}
+	start := 0
+	for i, succ := range succs {
 		if succ.ID.IsEqual(rn.ID) {
-			if i == len(n.successors)-1 {
-				cf = &n.successors[i-1]
-				break
-			} else if i < len(n.successors)-1 {
-				cf = &n.successors[i+1]
-				break
-			}
+			start = i + 1
+			break
 		}
 	}
-	if cf == nil {
-		return &n.successors[0]
+	for i := 0; i < len(succs); i++ {
+		cf := &succs[(start+i)%len(succs)]
+		if !cf.ID.IsEqual(rn.ID) && !n.remote.Suspect(*cf) {
+			return cf
+		}
 	}
-	return cf
+	return &succs[0]
 }
 
-// TODO: replay query to closest predeceding node
-func (n *Node) findSuccessor(id util.Identifier) (*comm.Rnode, error) {
-	pre, err := n.findPredecessor(id)
-	if err != nil {
-		return nil, err
-	}
-	succ, err := n.remote.GetSuccessor(*pre)
-	if err != nil {
-		return nil, err
-	}
-	return succ, nil
+// Locates the successor of id, see lookup
+func (n *Node) findSuccessor(ctx context.Context, id util.Identifier) (*comm.Rnode, error) {
+	succ, _, err := n.lookup(ctx, id)
+	return succ, err
 }
 
-// Finding closest predeceeding finger
+// Finding closest predeceeding finger. Fingers
+// suspected to have failed are passed over
 // TODO: Iterate successor list
 func (n *Node) closestPreFinger(id util.Identifier) *comm.Rnode {
+	n.nMu.RLock()
+	defer n.nMu.RUnlock()
 	for i := KeySize - 1; i >= 0; i-- {
-		if n.fingers[i].node != nil && n.fingers[i].node.ID.IsBetween(n.ID, id) {
+		if n.fingers[i].node != nil && n.fingers[i].node.ID.IsBetween(n.ID, id) &&
+			!n.remote.Suspect(*n.fingers[i].node) {
 			n.log.Info.Printf("Returning %s as closest pre\n", n.fingers[i].node.IP)
 			return n.fingers[i].node
 		}
@@ -275,6 +337,7 @@ func (n *Node) migrateKeys(from, to string) map[string]string {
 		if util.StringToID(k).InKeySpace(fromID, toID) {
 			mk[k] = v
 			delete(n.objectStore, k)
+			n.metrics.migrated.Add(float64(len(k) + len(v)))
 		}
 	}
 	return mk
@@ -282,17 +345,15 @@ func (n *Node) migrateKeys(from, to string) map[string]string {
 
 // Implemented as per Chord
 func (n *Node) notify(rn *comm.Rnode) {
-	if n.prev.ID.IsEqual(n.ID) || rn.ID.IsBetween(n.prev.ID, n.ID) {
+	n.peers.add(rn.IP)
+	prev := n.predecessor()
+	if prev.ID.IsEqual(n.ID) || rn.ID.IsBetween(prev.ID, n.ID) {
 		n.setPredecessor(rn)
-	}
-	if alive, _ := n.remote.IsAlive(*n.prev); !alive {
+	} else if alive, _ := n.remote.IsAlive(n.ctx, *prev); !alive {
 		n.setPredecessor(rn)
 	}
 
-	if n.fingers[0].node.ID.IsEqual(n.ID) {
-		n.setSuccessor(rn)
-	}
-	if len(n.successors) == 1 && n.successors[0].ID.IsEqual(n.ID) {
+	if n.successor().ID.IsEqual(n.ID) {
 		n.setSuccessor(rn)
 	}
 
@@ -301,14 +362,20 @@ func (n *Node) notify(rn *comm.Rnode) {
 
 // fixFinger
 func (n *Node) fixFinger() {
-	idx := rand.Intn(KeySize-1) + 1
-	newSucc, err := n.findSuccessor(n.fingers[idx].start)
+	idx := n.rand.Intn(KeySize-1) + 1
+	newSucc, err := n.findSuccessor(n.ctx, n.fingers[idx].start)
 	if err != nil {
-		log.Println(err)
+		n.log.Err.Println(err)
+		return
+	}
+	// Don't point a finger at a node we believe has failed
+	if n.remote.Suspect(*newSucc) || !n.trusted(*newSucc, "fixFinger") {
+		return
 	}
 
+	n.nMu.Lock()
 	n.fingers[idx].node = newSucc
-	n.updateSuccessors(newSucc)
+	n.nMu.Unlock()
 }
 
 // stabilize
@@ -317,119 +384,107 @@ func (n *Node) stabilize() {
 	var temp *comm.Rnode
 	var err error
 	skipped := false
-	if n.fingers[0].node.ID.IsEqual(n.ID) {
+	began := n.clock.Now()
+	defer func() { n.metrics.stabilize.Observe(n.clock.Now().Sub(began).Seconds()) }()
+	n.stabilized.started(began)
+	if n.probeDue() {
+		n.probe()
+	}
+	successor := *n.successor()
+	if successor.ID.IsEqual(n.ID) {
 		return
 	}
-	successor := n.successors[0]
-
-	// Try to query the first successor - skip the next on failure
-	for temp, err = n.remote.GetPredecessor(successor); ; {
-		if err != nil {
-			successor, err = n.skipSuccessor(&successor)
-			skipped = true
-			n.log.Info.Printf("%s\n", successor.IP)
-			if err == ErrExhausted {
-				n.setSuccessor(n.Rnode)
-				return
-			} else if err != nil {
-				n.log.Err.Printf("Has successor %s and got err: %s\n", successor.IP, err.Error())
-			}
-		} else {
+
+	// Try to query the first successor - skip the next on failure.
+	// A successor is only skipped once the failure detector suspects it;
+	// a single slow response just postpones this round
+	for {
+		temp, err = n.remote.GetPredecessor(n.ctx, successor)
+		if err == nil {
 			break
 		}
+		if !n.remote.Suspect(successor) {
+			n.log.Info.Printf("Successor %s did not respond (phi %.2f)\n",
+				successor.IP, n.remote.Phi(successor))
+			return
+		}
+		successor, err = n.skipSuccessor(&successor)
+		skipped = true
+		if err == ErrExhausted {
+			n.setSuccessor(n.Rnode)
+			return
+		} else if err != nil {
+			n.log.Err.Printf("Has successor %s and got err: %s\n", successor.IP, err.Error())
+			return
+		}
+		n.log.Info.Printf("Skipped to successor %s\n", successor.IP)
 	}
+	changed := skipped
+	defer func() { n.stabilized.reached(n.clock.Now(), changed) }()
 	if temp == nil {
 		return
 	}
 
 	// Setting new successor if it's in the node's successor's keyspace
-	if temp.ID.IsBetween(n.ID, successor.ID) ||
-		n.fingers[0].node.ID.IsEqual(n.ID) {
-		n.log.Info.Println(skipped)
-		if !skipped {
-			// Safeguard: checks for aliveness
-			if alive, _ := n.remote.IsAlive(*temp); alive {
-				n.setSuccessor(temp)
-			}
+	if temp.ID.IsBetween(n.ID, successor.ID) && !skipped && n.trusted(*temp, "GetPredecessor") {
+		// Safeguard: checks for aliveness
+		if alive, _ := n.remote.IsAlive(n.ctx, *temp); alive {
+			n.setSuccessor(temp)
+			successor = *temp
+			changed = true
 		}
 	}
 
-	n.remote.Notify(successor, n.Rnode)
+	n.remote.Notify(n.ctx, successor, n.Rnode)
 
-	n.checkSuccessors()
+	// Copy the successor's list, prepend the successor and truncate
+	list, err := n.remote.GetSuccessorList(n.ctx, successor)
+	if err != nil {
+		n.log.Err.Printf("Could not get successor list from %s: %s\n", successor.IP, err)
+	} else {
+		n.setSuccessorList(successor, n.trustedOnly(list, "GetSuccessorList"))
+	}
 
 	if !skipped {
 		n.fixFinger()
 	}
 }
 
-// Maintains the successor list according to aliveness
-func (n *Node) checkSuccessors() {
-	for i := 0; i < len(n.successors)-1; i++ {
-		s, err := n.remote.GetSuccessor(n.successors[i])
-		if err != nil {
-			n.successors = append(n.successors[:i], n.successors[i+1:]...)
-			return
-		} else {
-			n.successors[i+1] = *s
-		}
-	}
-}
-
-// Adds a successor to the successor list
-func (n *Node) updateSuccessors(nsucc *comm.Rnode) error {
+// Skips to the next successor if the nearmost successor has failed
+func (n *Node) skipSuccessor(s *comm.Rnode) (comm.Rnode, error) {
 	n.nMu.Lock()
 	defer n.nMu.Unlock()
-
-	if nsucc.ID.IsEqual(n.ID) {
-		return nil
-	}
-
-	found := false
-	for _, succ := range n.successors {
-		if succ.ID.IsEqual(nsucc.ID) {
-			found = true
-			break
-		}
-	}
-	if !found {
-		if len(n.successors) == 0 {
-			n.successors = append(n.successors, *nsucc)
-		} else {
-			for i, succ := range n.successors {
-				if nsucc.ID.IsBetweenEqual(succ.ID, n.successors[(i+1)%len(n.successors)].ID) {
-					n.successors = append(n.successors, comm.Rnode{})
-					copy(n.successors[i+1:], n.successors[i:])
-					n.successors[i+1] = *nsucc
-				}
-			}
-		}
+	if len(n.successors) == 0 {
+		return comm.Rnode{}, ErrExhausted
 	}
-	return nil
-}
-
-// Skips to the next successor if the nearmost successor has failed
-func (n *Node) skipSuccessor(s *comm.Rnode) (comm.Rnode, error) {
 	if !n.successors[0].ID.IsEqual(s.ID) {
 		return comm.Rnode{}, ErrNotFirst
 	}
-	n.successors = append(n.successors[:0], n.successors[1:]...)
+	n.successors = append([]comm.Rnode(nil), n.successors[1:]...)
 	if len(n.successors) == 0 {
 		return comm.Rnode{}, ErrExhausted
 	}
-	n.fingers[0].node = &n.successors[0]
+	n.setFirstFinger(n.successors[0])
 	return n.successors[0], nil
 }
 
-// Callback
-func (n *Node) failhandler(rn *comm.Rnode) {
-
+// Starts the stabilize routine unless the node is driven manually
+func (n *Node) runStabilize() {
+	if !n.cfg.Manual {
+		go n.periodicRun()
+	}
 }
 
-// runs the stabilize routine periodically
+// runs the stabilize routine periodically, or right away
+// when a failed peer has been purged
 func (n *Node) periodicRun() {
 	for {
-		time.Sleep(time.Second * 1)
+		select {
+		case <-n.clock.After(n.cfg.StabilizeInterval):
+		case <-n.stabilizeNow:
+		case <-n.quit:
+			return
+		}
 		n.stabilize()
 	}
 }
@@ -440,7 +495,11 @@ func (n *Node) pushState() {
 	for {
 		b := n.createState()
 		n.sendState("update", b)
-		time.Sleep(time.Millisecond * 1000)
+		select {
+		case <-n.clock.After(time.Millisecond * 1000):
+		case <-n.quit:
+			return
+		}
 	}
 }
 
@@ -451,9 +510,9 @@ func (n *Node) createState() bytes.Buffer {
 		ID   string
 		Prev string
 	}{
-		n.fingers[0].node.IP,
+		n.successor().IP,
 		n.IP,
-		n.prev.IP,
+		n.predecessor().IP,
 	}
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(state)
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/util"
+)
+
+func rnode(id string) comm.Rnode {
+	return comm.Rnode{ID: util.StringToID(id), IP: id}
+}
+
+func TestBuildSuccessors(t *testing.T) {
+	self := rnode("c")
+	n := &Node{Rnode: &self, nSuccessors: 3}
+
+	// Successor's list wraps around to n and has a duplicate
+	list := []comm.Rnode{rnode("e"), rnode("e"), rnode("a"), rnode("c"), rnode("d")}
+	succs := n.buildSuccessors(rnode("d"), list)
+
+	want := []string{"d", "e", "a"}
+	if len(succs) != len(want) {
+		t.Fatalf("expected %d successors, got %d", len(want), len(succs))
+	}
+	for i, s := range succs {
+		if s.IP != want[i] {
+			t.Errorf("successor %d: expected %s, got %s", i, want[i], s.IP)
+		}
+	}
+}
+
+func TestBuildSuccessorsStopsAtSelf(t *testing.T) {
+	self := rnode("c")
+	n := &Node{Rnode: &self, nSuccessors: 4}
+
+	succs := n.buildSuccessors(rnode("d"), []comm.Rnode{rnode("c"), rnode("d")})
+	if len(succs) != 1 || succs[0].IP != "d" {
+		t.Errorf("expected only d, got %v", succs)
+	}
+	if succs = n.buildSuccessors(self, nil); len(succs) != 0 {
+		t.Errorf("a node alone should have no successors, got %v", succs)
+	}
+}
+
+func TestForgedClaimDoesNotBlacklistOwner(t *testing.T) {
+	n, err := New(Config{Log: DiscardLogger(), StrictIDs: true, Clock: util.NewFakeClock(time.Unix(0, 0))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	honest := comm.Rnode{ID: util.StringToID(util.HashValue("10.0.0.1:8011")), IP: "10.0.0.1:8011"}
+	forged := comm.Rnode{ID: util.StringToID(util.HashValue("anywhere")), IP: honest.IP}
+
+	if n.trusted(forged, "test") {
+		t.Error("expected a foreign identifier to be rejected")
+	}
+	if n.trusted(forged, "test") || !n.trusted(honest, "test") {
+		t.Error("expected only the forged claim to be blacklisted")
+	}
+	if c := n.blacklist.counts(n.clock.Now()); len(c.Listed) != 1 || c.Rejected != 2 {
+		t.Errorf("unexpected blacklist %+v", c)
+	}
+}
@@ -0,0 +1,165 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/util"
+)
+
+// Peer A node as shown by the admin API, with a hex encoded ID
+type Peer struct {
+	ID string
+	IP string
+}
+
+func toPeer(rn *comm.Rnode) Peer {
+	if rn == nil {
+		return Peer{}
+	}
+	return Peer{ID: hex.EncodeToString(rn.ID), IP: rn.IP}
+}
+
+// FingerRange Consecutive fingers pointing at the same node
+type FingerRange struct {
+	// First and Last finger index of the range
+	First int
+	Last  int
+	// Start identifier of the first finger
+	Start string
+	Node  Peer
+}
+
+// StabilizeTimes Timestamps of the stabilize routine
+type StabilizeTimes struct {
+	Rounds int
+	// Last round started, and last round that reached the successor
+	Last   time.Time
+	LastOK time.Time
+	// SuccessorChanged when the successor was last replaced
+	SuccessorChanged time.Time
+}
+
+// RoutingState Complete routing state of a node, as served by
+// GET /admin/routing
+type RoutingState struct {
+	Node        Peer
+	Predecessor Peer
+	Successors  []Peer
+	// Keys owned by the node lie in (From, To]
+	From string
+	To   string
+	// Keys stored and the bytes taken by keys and values
+	Keys         int
+	StorageBytes int
+	// Peers and connections of the RPC pool; zero for other transports
+	RPCPeers  int
+	RPCConns  int
+	Fingers   []FingerRange
+	Stabilize StabilizeTimes
+}
+
+// stabilizeLog Records when the stabilize routine ran
+type stabilizeLog struct {
+	sync.Mutex
+	t StabilizeTimes
+}
+
+func (s *stabilizeLog) started(now time.Time) {
+	s.Lock()
+	s.t.Rounds++
+	s.t.Last = now
+	s.Unlock()
+}
+
+func (s *stabilizeLog) reached(now time.Time, changed bool) {
+	s.Lock()
+	s.t.LastOK = now
+	if changed {
+		s.t.SuccessorChanged = now
+	}
+	s.Unlock()
+}
+
+func (s *stabilizeLog) times() StabilizeTimes {
+	s.Lock()
+	defer s.Unlock()
+	return s.t
+}
+
+// routingState Collects the routing state of n
+func (n *Node) routingState() RoutingState {
+	st := RoutingState{
+		Node:        toPeer(n.Rnode),
+		Predecessor: toPeer(n.predecessor()),
+		Stabilize:   n.stabilized.times(),
+	}
+	st.From, st.To = st.Predecessor.ID, st.Node.ID
+	for _, s := range n.successorList() {
+		st.Successors = append(st.Successors, toPeer(&s))
+	}
+
+	n.nMu.RLock()
+	for i, f := range n.fingers {
+		p := toPeer(f.node)
+		if last := len(st.Fingers) - 1; last >= 0 && st.Fingers[last].Node == p {
+			st.Fingers[last].Last = i
+			continue
+		}
+		st.Fingers = append(st.Fingers, FingerRange{First: i, Last: i, Start: hex.EncodeToString(f.start), Node: p})
+	}
+	n.nMu.RUnlock()
+
+	st.Keys, st.StorageBytes = n.storeSize()
+
+	if t, ok := n.cfg.Transport.(*netutils.RPCTransport); ok {
+		stats := t.Stats()
+		st.RPCPeers, st.RPCConns = stats.Peers, stats.Conns
+	}
+	return st
+}
+
+// routing Serves the routing state as JSON, or as text with ?format=text
+func (n *Node) routing(w http.ResponseWriter, r *http.Request) {
+	st := n.routingState()
+	if r.URL.Query().Get("format") != "text" {
+		util.WriteJson(w, st)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	st.WriteText(w)
+}
+
+// WriteText Writes s in human readable form
+func (s *RoutingState) WriteText(w io.Writer) {
+	stamp := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format(time.RFC3339)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "node\t%s\t%s\n", s.Node.IP, s.Node.ID)
+	fmt.Fprintf(tw, "predecessor\t%s\t%s\n", s.Predecessor.IP, s.Predecessor.ID)
+	fmt.Fprintf(tw, "range\t(%s, %s]\n", s.From, s.To)
+	fmt.Fprintf(tw, "keys\t%d (%d bytes)\n", s.Keys, s.StorageBytes)
+	fmt.Fprintf(tw, "rpc\t%d conns to %d peers\n", s.RPCConns, s.RPCPeers)
+	fmt.Fprintf(tw, "stabilize\t%d rounds, last %s, last ok %s, successor changed %s\n",
+		s.Stabilize.Rounds, stamp(s.Stabilize.Last), stamp(s.Stabilize.LastOK), stamp(s.Stabilize.SuccessorChanged))
+	fmt.Fprintln(tw, "\nsuccessors")
+	for i, p := range s.Successors {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\n", i, p.IP, p.ID)
+	}
+	fmt.Fprintln(tw, "\nfingers\tstart\tnode")
+	for _, f := range s.Fingers {
+		fmt.Fprintf(tw, "  %d-%d\t%s\t%s\t%s\n", f.First, f.Last, f.Start, f.Node.IP, f.Node.ID)
+	}
+	tw.Flush()
+}
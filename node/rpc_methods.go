@@ -1,141 +1,226 @@
 package node
 
 import (
+	"context"
+
 	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
 	"github.com/hoffa2/chord/util"
 )
 
+// rpcServer Exposes a node's NodeComm methods over RPC
+type rpcServer struct {
+	*Node
+}
+
+// callContext Bounds the work done for a call by the time its caller has left
+func (n *rpcServer) callContext(ctx context.Context, args *comm.Args) (context.Context, context.CancelFunc) {
+	if args.Timeout > 0 {
+		return context.WithTimeout(ctx, args.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
 // FindPredecessor RPC call to find a predecessor of Key on node n
-func (n *Node) FindPredecessor(args *comm.Args, reply *comm.NodeID) error {
-	n.nMu.RLock()
-	defer n.nMu.RUnlock()
+func (n *rpcServer) FindPredecessor(args *comm.Args, reply *comm.NodeID) error {
 	key := util.Identifier(args.ID)
 
-	if n.ID.IsEqual(n.prev.ID) {
+	if n.ID.IsEqual(n.predecessor().ID) {
 		reply.ID = n.ID.ToString()
 		reply.IP = n.IP
 		return nil
 	}
 
-	pre, err := n.findPredecessor(key)
-	if err != nil {
-		return err
-	}
+	return n.traced("FindPredecessor", args, func(ctx context.Context) error {
+		ctx, cancel := n.callContext(ctx, args)
+		defer cancel()
+		pre, err := n.findPredecessor(ctx, key)
+		if err != nil {
+			return err
+		}
 
-	reply.ID = pre.ID.ToString()
-	reply.IP = pre.IP
-	return err
+		reply.ID = pre.ID.ToString()
+		reply.IP = pre.IP
+		return nil
+	})
 }
 
 // FindPredecessor RPC call to find a predecessor of Key on node n
-func (n *Node) FindSuccessor(args *comm.Args, reply *comm.NodeID) error {
-	n.nMu.RLock()
-	defer n.nMu.RUnlock()
+func (n *rpcServer) FindSuccessor(args *comm.Args, reply *comm.NodeID) error {
 	key := util.Identifier(args.ID)
 
-	if n.ID.IsEqual(n.fingers[0].node.ID) {
+	if n.ID.IsEqual(n.successor().ID) {
 		reply.ID = n.ID.ToString()
 		reply.IP = n.IP
 		return nil
 	}
 
-	succ, err := n.findSuccessor(key)
-	if err != nil {
-		return err
-	}
+	return n.traced("FindSuccessor", args, func(ctx context.Context) error {
+		ctx, cancel := n.callContext(ctx, args)
+		defer cancel()
+		succ, err := n.findSuccessor(ctx, key)
+		if err != nil {
+			return err
+		}
 
-	reply.ID = succ.ID.ToString()
-	reply.IP = succ.IP
-	return err
+		reply.ID = succ.ID.ToString()
+		reply.IP = succ.IP
+		return nil
+	})
 }
 
 // FindSuccessor Finding the successor of n
-func (n *Node) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
-	n.nMu.RLock()
-	defer n.nMu.RUnlock()
+func (n *rpcServer) GetSuccessor(args *comm.Empty, reply *comm.NodeID) error {
+	return n.traced("GetSuccessor", args, func(ctx context.Context) error {
+		n.nMu.RLock()
+		defer n.nMu.RUnlock()
 
-	reply.IP = n.fingers[0].node.IP
-	reply.ID = n.fingers[0].node.ID.ToString()
-	return nil
+		reply.IP = n.fingers[0].node.IP
+		reply.ID = n.fingers[0].node.ID.ToString()
+		return nil
+	})
+}
+
+// GetSuccessorList Returns n's successor list, nearest successor first
+func (n *rpcServer) GetSuccessorList(args *comm.Empty, reply *[]comm.NodeID) error {
+	return n.traced("GetSuccessorList", args, func(ctx context.Context) error {
+		succs := n.successorList()
+		list := make([]comm.NodeID, len(succs))
+		for i, s := range succs {
+			list[i] = comm.NodeID{ID: s.ID.ToString(), IP: s.IP}
+		}
+		*reply = list
+		return nil
+	})
 }
 
-func (n *Node) GetPredecessor(args *comm.Empty, reply *comm.NodeID) error {
-	n.nMu.RLock()
-	defer n.nMu.RUnlock()
+func (n *rpcServer) GetPredecessor(args *comm.Empty, reply *comm.NodeID) error {
+	return n.traced("GetPredecessor", args, func(ctx context.Context) error {
+		n.nMu.RLock()
+		defer n.nMu.RUnlock()
 
-	reply.IP = n.prev.IP
-	reply.ID = n.prev.ID.ToString()
-	return nil
+		reply.IP = n.prev.IP
+		reply.ID = n.prev.ID.ToString()
+		return nil
+	})
 }
 
 // UpdatePredecessor Updates n's predecessor and initializes an RPC connection
-func (n *Node) UpdatePredecessor(args *comm.NodeID, reply *comm.Empty) error {
-	IP := args.IP
-	ID := args.ID
-
-	err := n.setPredecessor(&comm.Rnode{ID: util.StringToID(ID), IP: IP})
-	if err != nil {
-		return err
-	}
-	return nil
+func (n *rpcServer) UpdatePredecessor(args *comm.NodeID, reply *comm.Empty) error {
+	return n.traced("UpdatePredecessor", args, func(ctx context.Context) error {
+		IP := args.IP
+		ID := args.ID
+
+		err := n.admit("UpdatePredecessor", util.StringToID(ID), IP, args.Proof)
+		if err != nil {
+			return err
+		}
+		if !n.trusted(comm.Rnode{ID: util.StringToID(ID), IP: IP}, "UpdatePredecessor") {
+			return ErrUntrustedID
+		}
+		return n.setPredecessor(&comm.Rnode{ID: util.StringToID(ID), IP: IP})
+	})
 }
 
 // PutRemote Gets an RPC put request to store a Key/Value pair
-func (n *Node) PutRemote(args *comm.KeyValue, reply *comm.Empty) error {
-	n.putValue(util.StringToID(args.Key), []byte(args.Value))
-	return nil
+func (n *rpcServer) PutRemote(args *comm.KeyValue, reply *comm.Empty) error {
+	return n.traced("PutRemote", args, func(ctx context.Context) error {
+		n.putValue(util.StringToID(args.Key), []byte(args.Value))
+		return nil
+	})
 }
 
 // GetRemote Gets an RPC put request to store a Key/Value pair
-func (n *Node) GetRemote(args *comm.KeyValue, reply *comm.KeyValue) error {
-	val, err := n.getValue(util.StringToID(args.Key))
-	if err != nil {
-		return err
-	}
-	reply.Value = val
-	return nil
+func (n *rpcServer) GetRemote(args *comm.KeyValue, reply *comm.KeyValue) error {
+	return n.traced("GetRemote", args, func(ctx context.Context) error {
+		val, err := n.getValue(util.StringToID(args.Key))
+		if err != nil {
+			return err
+		}
+		reply.Value = val
+		return nil
+	})
 }
 
 // UpdateSuccessor Updates node n's successor and initializes an RPC connection
-func (n *Node) UpdateSuccessor(args *comm.NodeID, reply *comm.Empty) error {
-	IP := args.IP
-	ID := args.ID
+func (n *rpcServer) UpdateSuccessor(args *comm.NodeID, reply *comm.Empty) error {
+	return n.traced("UpdateSuccessor", args, func(ctx context.Context) error {
+		IP := args.IP
+		ID := args.ID
+
+		err := n.admit("UpdateSuccessor", util.StringToID(ID), IP, args.Proof)
+		if err != nil {
+			return err
+		}
+		if !n.trusted(comm.Rnode{ID: util.StringToID(ID), IP: IP}, "UpdateSuccessor") {
+			return ErrUntrustedID
+		}
+		return n.setSuccessor(&comm.Rnode{ID: util.StringToID(ID), IP: IP})
+	})
+}
 
-	err := n.setSuccessor(&comm.Rnode{ID: util.StringToID(ID), IP: IP})
-	if err != nil {
+// Init asserts a successful RPC init and rejects peers
+// this node cannot work with
+func (n *rpcServer) Init(args *comm.Handshake, reply *comm.Handshake) error {
+	if err := netutils.CheckHandshake(n.handshake, *args); err != nil {
+		n.log.Err.Printf("Rejecting connection: %v\n", err)
 		return err
 	}
-	return nil
-}
-
-// Init convenience function to assert successful RPC init
-func (n *Node) Init(args *comm.Args, reply *comm.NodeID) error {
+	*reply = n.handshake
 	reply.ID = args.ID
 	return nil
 }
 
-func (n *Node) ClosestPreFinger(args *string, reply *comm.NodeID) error {
-	rnode := n.closestPreFinger(util.StringToID(*args))
+// ClosestPreFinger Returns the finger of n closest preceding id.
+// Serves peers predating ClosestPreFingerArgs
+func (n *rpcServer) ClosestPreFinger(id *string, reply *comm.NodeID) error {
+	rnode := n.closestPreFinger(util.StringToID(*id))
 	*reply = comm.NodeID{ID: rnode.ID.ToString(), IP: rnode.IP}
 	return nil
 }
 
+// ClosestPreFingerArgs Returns the finger of n closest preceding args.ID
+func (n *rpcServer) ClosestPreFingerArgs(args *comm.Args, reply *comm.NodeID) error {
+	return n.traced("ClosestPreFinger", args, func(ctx context.Context) error {
+		return n.ClosestPreFinger(&args.ID, reply)
+	})
+}
+
 // UpdateFingerTable Updates n's fingertable's i'th entry
-func (n *Node) UpdateFingerTable(args *comm.FingerEntry, reply *comm.Empty) error {
+func (n *rpcServer) UpdateFingerTable(args *comm.FingerEntry, reply *comm.Empty) error {
 	return nil
 }
 
-func (n *Node) GetKeysInInterval(ival *comm.Interval, reply *comm.Keys) error {
-	*reply = n.migrateKeys(ival.From, ival.To)
-	return nil
+func (n *rpcServer) GetKeysInInterval(ival *comm.Interval, reply *comm.Keys) error {
+	return n.traced("GetKeysInInterval", ival, func(ctx context.Context) error {
+		*reply = n.migrateKeys(ival.From, ival.To)
+		return nil
+	})
 }
 
-func (n *Node) Notify(node *comm.Rnode, reply *comm.Empty) error {
-	n.notify(node)
-	return nil
+func (n *rpcServer) Notify(node *comm.Member, reply *comm.Empty) error {
+	return n.traced("Notify", node, func(ctx context.Context) error {
+		if err := n.admit("Notify", node.ID, node.IP, node.Proof); err != nil {
+			return err
+		}
+		rn := &comm.Rnode{ID: node.ID, IP: node.IP}
+		if !n.trusted(*rn, "Notify") {
+			return ErrUntrustedID
+		}
+		n.notify(rn)
+		return nil
+	})
 }
 
-func (n *Node) Leave(in *comm.Empty, out *comm.Empty) error {
-	n.exitChan <- "exit"
+// Leave makes n leave the ring and shut down once the call has returned.
+// Refused if the HTTP API requires authentication, which covers leaving
+func (n *rpcServer) Leave(in *comm.Empty, out *comm.Empty) error {
+	if n.cfg.Auth != nil {
+		return ErrAdminOnly
+	}
+	go func() {
+		n.Node.Leave()
+		n.Close()
+	}()
 	return nil
 }
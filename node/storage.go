@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 
@@ -8,11 +9,17 @@ import (
 	"github.com/hoffa2/chord/util"
 )
 
+// requestContext Derives the context of the calls made for r. It ends when
+// the client goes away or the server would stop waiting for a response
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), requestTimeout)
+}
+
 func (n *Node) putValue(key util.Identifier, body []byte) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.objectStore[key.ToString()] = string(body)
-	if !key.InKeySpace(n.prev.ID, n.ID) {
+	if !key.InKeySpace(n.predecessor().ID, n.ID) {
 		n.log.Err.Printf("Key %s is not in %s's keyspace\n", key.ToString(), n.IP)
 	}
 }
@@ -26,26 +33,26 @@ func (n *Node) getValue(key util.Identifier) (string, error) {
 		return "", ErrNotFound
 	}
 
-	if !key.InKeySpace(n.prev.ID, n.ID) {
+	if !key.InKeySpace(n.predecessor().ID, n.ID) {
 		n.log.Err.Printf("Key %s is not in %s's keyspace\n", key.ToString(), n.IP)
 	}
 	return val, nil
 }
 
-func (n Node) sendToSuccessor(key, val string, s *comm.Rnode) error {
+func (n *Node) sendToSuccessor(ctx context.Context, key, val string, s *comm.Rnode) error {
 	var err error
 
-	err = n.remote.PutRemote(*s, key, val)
+	err = n.remote.PutRemote(ctx, *s, key, val)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (n Node) getFromSuccessor(key string, s *comm.Rnode) (string, error) {
+func (n *Node) getFromSuccessor(ctx context.Context, key string, s *comm.Rnode) (string, error) {
 	var err error
 
-	val, err := n.remote.GetRemote(*s, key)
+	val, err := n.remote.GetRemote(ctx, *s, key)
 	if err != nil {
 		return "", err
 	}
@@ -64,7 +71,9 @@ func (n *Node) putKey(w http.ResponseWriter, r *http.Request) {
 
 	KID := util.StringToID(util.HashValue(key))
 
-	s, err := n.findKeySuccessor(KID)
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	s, err := n.findKeySuccessor(ctx, KID)
 	if err != nil {
 		n.log.Err.Printf("Could not find %s's successor\n", key)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -73,7 +82,7 @@ func (n *Node) putKey(w http.ResponseWriter, r *http.Request) {
 	if s.ID.IsEqual(n.ID) {
 		n.putValue(KID, body)
 	} else {
-		err = n.sendToSuccessor(KID.ToString(), string(body), s)
+		err = n.sendToSuccessor(ctx, KID.ToString(), string(body), s)
 		if err != nil {
 			n.log.Err.Printf("Could not find %s's successor\n", key)
 			// TODO: Notify the actual error in some way
@@ -92,7 +101,9 @@ func (n *Node) getKey(w http.ResponseWriter, r *http.Request) {
 	key := readKey(r)
 	KID := util.StringToID(util.HashValue(key))
 
-	s, err := n.findKeySuccessor(KID)
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	s, err := n.findKeySuccessor(ctx, KID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -100,7 +111,7 @@ func (n *Node) getKey(w http.ResponseWriter, r *http.Request) {
 	if s.ID.IsEqual(n.ID) {
 		val, err = n.getValue(KID)
 	} else {
-		val, err = n.getFromSuccessor(KID.ToString(), s)
+		val, err = n.getFromSuccessor(ctx, KID.ToString(), s)
 	}
 	if err == ErrNotFound {
 		util.ErrorNotFound(w, "Key %s not found", key)
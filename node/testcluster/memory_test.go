@@ -0,0 +1,213 @@
+package testcluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/util"
+)
+
+// Stabilization runs on a fake clock, so these tests take milliseconds
+func fakeClock() *util.FakeClock {
+	return util.NewFakeClock(time.Unix(0, 0))
+}
+
+func TestInMemoryJoin(t *testing.T) {
+	c, err := NewInMemory(10, node.Config{Clock: fakeClock()}, netutils.NewMemNetwork(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryFailure(t *testing.T) {
+	c, err := NewInMemory(8, node.Config{Successors: 3, Clock: fakeClock()}, netutils.NewMemNetwork(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Remove(3)
+	c.Remove(3)
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryLossyNetwork(t *testing.T) {
+	network := netutils.NewMemNetwork(1)
+	network.SetLatency(time.Millisecond, time.Millisecond)
+	network.SetReorder(time.Millisecond * 2)
+
+	c, err := NewInMemory(6, node.Config{}, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Losing calls must delay stabilization, not break the ring
+	network.SetDropRate(0.05, 0)
+	if err := c.WaitStable(time.Second * 20); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryPartitionedNodeIsDropped(t *testing.T) {
+	network := netutils.NewMemNetwork(1)
+	cfg := node.Config{PhiThreshold: netutils.DefaultPhiThreshold, Clock: fakeClock()}
+	c, err := NewInMemory(5, cfg, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut one node off; calls to it time out until it is suspected
+	isolated := c.Nodes[2]
+	var rest []string
+	for _, n := range c.Nodes {
+		if n != isolated {
+			rest = append(rest, n.IP)
+		}
+	}
+	network.Partition(rest)
+	c.Nodes = append(c.Nodes[:2], c.Nodes[3:]...)
+	defer isolated.Close()
+
+	if err := c.WaitStable(time.Second * 20); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryIntruderIsRejected(t *testing.T) {
+	network := netutils.NewMemNetwork(1)
+	cfg := node.Config{ClusterSecret: "secret", Clock: fakeClock()}
+	c, err := NewInMemory(4, cfg, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// A node with the wrong secret may look up its place in the
+	// ring, but must not become anyone's neighbor
+	cfg.ClusterSecret = "guess"
+	cfg.Manual = true
+	cfg.Log = node.DiscardLogger()
+	cfg.RPCAddr = "127.0.0.1:0"
+	cfg.Transport = network.Transport()
+	intruder, err := node.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := intruder.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer intruder.Close()
+	if err := intruder.Join(c.Nodes[0].IP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		intruder.Stabilize()
+	}
+	intruder.Leave()
+
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemorySpoofedIDIsBlacklisted(t *testing.T) {
+	network := netutils.NewMemNetwork(1)
+	cfg := node.Config{StrictIDs: true, Clock: fakeClock()}
+	c, err := NewInMemory(4, cfg, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// A node choosing its own position in the ring is not let in
+	cfg.StrictIDs = false
+	cfg.ID = util.StringToID(util.HashValue("anywhere"))
+	cfg.Manual = true
+	cfg.Log = node.DiscardLogger()
+	cfg.RPCAddr = "127.0.0.1:0"
+	cfg.Transport = network.Transport()
+	spoofer, err := node.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spoofer.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+	if err := spoofer.Join(c.Nodes[0].IP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		spoofer.Stabilize()
+	}
+
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryPartitionedRingsMerge(t *testing.T) {
+	network := netutils.NewMemNetwork(1)
+	cfg := node.Config{
+		PhiThreshold:  netutils.DefaultPhiThreshold,
+		ProbeInterval: time.Second,
+		Clock:         fakeClock(),
+	}
+	c, err := NewInMemory(6, cfg, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interleaved halves each form a ring of their own
+	halves := []*Cluster{{}, {}}
+	groups := make([][]string, 2)
+	for i, n := range c.Sorted() {
+		halves[i%2].Nodes = append(halves[i%2].Nodes, n)
+		groups[i%2] = append(groups[i%2], n.IP)
+	}
+	network.Partition(groups...)
+	err = c.WaitFor(time.Second*30, func() error {
+		for _, h := range halves {
+			if err := h.Check(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stabilize alone never joins the two rings again
+	network.Heal()
+	if err := c.WaitStable(time.Second * 60); err != nil {
+		t.Fatal(err)
+	}
+}
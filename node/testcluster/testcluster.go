@@ -0,0 +1,180 @@
+// Package testcluster runs a ring of chord nodes inside one process.
+// Nodes listen on ephemeral localhost ports, or talk over an in-memory
+// network, so tests can start several clusters in parallel without a
+// nameserver or the Rocks cluster.
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/util"
+)
+
+var (
+	// ErrNotStable if the ring did not stabilize in time
+	ErrNotStable = errors.New("ring did not stabilize")
+)
+
+// Cluster A set of in-process nodes forming one ring
+type Cluster struct {
+	Nodes []*node.Node
+	// Network the nodes talk over; nil when they use sockets
+	Network *netutils.MemNetwork
+	// Template used for nodes added after creation
+	cfg node.Config
+	// Set when cfg.Clock is a FakeClock
+	clock *util.FakeClock
+}
+
+// New starts n nodes configured from cfg and joins them into one ring.
+// Listen and advertise addresses in cfg are overridden per node
+func New(n int, cfg node.Config) (*Cluster, error) {
+	return start(n, cfg, nil)
+}
+
+// NewInMemory starts n nodes that talk over network instead of sockets.
+// The nodes serve no HTTP API
+func NewInMemory(n int, cfg node.Config, network *netutils.MemNetwork) (*Cluster, error) {
+	return start(n, cfg, network)
+}
+
+func start(n int, cfg node.Config, network *netutils.MemNetwork) (*Cluster, error) {
+	if cfg.StabilizeInterval == 0 {
+		cfg.StabilizeInterval = time.Millisecond * 50
+	}
+	if cfg.Log == nil {
+		cfg.Log = node.DiscardLogger()
+	}
+	c := &Cluster{cfg: cfg, Network: network}
+	c.clock, _ = cfg.Clock.(*util.FakeClock)
+	for i := 0; i < n; i++ {
+		_, err := c.Add()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Add starts another node and joins it through the first node
+func (c *Cluster) Add() (*node.Node, error) {
+	cfg := c.cfg
+	cfg.RPCAddr = "127.0.0.1:0"
+	cfg.HTTPAddr = "127.0.0.1:0"
+	cfg.Advertise = ""
+	cfg.AdvertiseHTTP = ""
+	cfg.ID = nil
+	if c.Network != nil {
+		cfg.Transport = c.Network.Transport()
+		cfg.HTTPAddr = ""
+	}
+
+	n, err := node.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = n.Start(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap := ""
+	if len(c.Nodes) > 0 {
+		bootstrap = c.Nodes[0].IP
+	}
+	err = n.Join(bootstrap)
+	if err != nil {
+		n.Close()
+		return nil, err
+	}
+	c.Nodes = append(c.Nodes, n)
+	return n, nil
+}
+
+// Remove closes the i'th node without leaving gracefully
+func (c *Cluster) Remove(i int) {
+	c.Nodes[i].Close()
+	c.Nodes = append(c.Nodes[:i], c.Nodes[i+1:]...)
+}
+
+// Sorted Returns the nodes ordered by identifier
+func (c *Cluster) Sorted() []*node.Node {
+	nodes := append([]*node.Node(nil), c.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID.IsLess(nodes[j].ID)
+	})
+	return nodes
+}
+
+// Check Returns an error describing the first node whose
+// successor or predecessor differs from the ring order
+func (c *Cluster) Check() error {
+	nodes := c.Sorted()
+	for i, n := range nodes {
+		next := nodes[(i+1)%len(nodes)]
+		prev := nodes[(i+len(nodes)-1)%len(nodes)]
+		if succ := n.Successor(); !succ.ID.IsEqual(next.ID) {
+			return fmt.Errorf("%s has successor %s, expected %s", n.IP, succ.IP, next.IP)
+		}
+		if pred := n.Predecessor(); !pred.ID.IsEqual(prev.ID) {
+			return fmt.Errorf("%s has predecessor %s, expected %s", n.IP, pred.IP, prev.IP)
+		}
+	}
+	return nil
+}
+
+// WaitStable polls until every node's successor and predecessor
+// match the ring order, or timeout passes. With a FakeClock the
+// clock is advanced one stabilize interval at a time instead, and
+// timeout is measured on that clock
+func (c *Cluster) WaitStable(timeout time.Duration) error {
+	return c.WaitFor(timeout, c.Check)
+}
+
+// WaitFor polls check like WaitStable polls the ring order
+func (c *Cluster) WaitFor(timeout time.Duration, check func() error) error {
+	if c.clock != nil {
+		return c.tick(timeout, check)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: %s", ErrNotStable, err)
+		}
+		time.Sleep(c.cfg.StabilizeInterval)
+	}
+}
+
+// Advances the fake clock once every node waits for its next
+// stabilize round, until check passes
+func (c *Cluster) tick(timeout time.Duration, check func() error) error {
+	for elapsed := time.Duration(0); ; elapsed += c.cfg.StabilizeInterval {
+		c.clock.BlockUntil(len(c.Nodes))
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if elapsed >= timeout {
+			return fmt.Errorf("%s: %s", ErrNotStable, err)
+		}
+		c.clock.Advance(c.cfg.StabilizeInterval)
+	}
+}
+
+// Close shuts down every node
+func (c *Cluster) Close() {
+	for _, n := range c.Nodes {
+		n.Close()
+	}
+}
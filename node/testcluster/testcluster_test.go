@@ -0,0 +1,377 @@
+package testcluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/tracing"
+	"github.com/hoffa2/chord/util"
+)
+
+func put(t *testing.T, n *node.Node, key, val string) {
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s/%s", n.HTTPAddr(), key), strings.NewReader(val))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT %s returned %d", key, resp.StatusCode)
+	}
+}
+
+func get(t *testing.T, n *node.Node, key string) string {
+	resp, err := http.Get(fmt.Sprintf("http://%s/%s", n.HTTPAddr(), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d: %s", key, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+// Fingers pointing at departed nodes are only repaired lazily,
+// so reads may fail until the failure has been noticed
+func getEventually(t *testing.T, n *node.Node, key string) string {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		resp, err := http.Get(fmt.Sprintf("http://%s/%s", n.HTTPAddr(), key))
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return string(body)
+			}
+		}
+		if time.Now().After(deadline) {
+			return get(t, n, key)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestClusterStabilizes(t *testing.T) {
+	c, err := New(5, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		put(t, c.Nodes[i%len(c.Nodes)], key, key)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if val := get(t, c.Nodes[(i+2)%len(c.Nodes)], key); val != key {
+			t.Errorf("expected %s, got %s", key, val)
+		}
+	}
+}
+
+func TestClusterLeave(t *testing.T) {
+	c, err := New(4, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+	put(t, c.Nodes[0], "stays", "around")
+
+	for i, n := range c.Nodes {
+		if i == 0 {
+			continue
+		}
+		if err := n.Leave(); err != nil {
+			t.Fatal(err)
+		}
+		c.Remove(i)
+		break
+	}
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+	if val := getEventually(t, c.Nodes[0], "stays"); val != "around" {
+		t.Errorf("key lost after leave, got %s", val)
+	}
+}
+
+func TestClusterFailure(t *testing.T) {
+	c, err := New(5, node.Config{Successors: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Remove(2)
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Peers predating protocol version 5 send ClosestPreFinger a bare identifier
+func TestClusterServesLegacyClosestPreFinger(t *testing.T) {
+	c, err := New(3, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := netutils.ConnectRPC(c.Nodes[0].IP, "", netutils.CodecGob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := util.HashValue("key")
+	var legacy, current comm.NodeID
+	if err := conn.Call(context.Background(), "NodeComm.ClosestPreFinger", &id, &legacy); err != nil {
+		t.Fatalf("legacy request rejected: %v", err)
+	}
+	if err := conn.Call(context.Background(), "NodeComm.ClosestPreFingerArgs", &comm.Args{ID: id}, &current); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.IP == "" || legacy.IP != current.IP {
+		t.Errorf("expected the same finger for both requests, got %s and %s", legacy.IP, current.IP)
+	}
+}
+
+func TestClusterLookupTrace(t *testing.T) {
+	c, err := New(4, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		n := c.Nodes[i%len(c.Nodes)]
+		resp, err := http.Get(fmt.Sprintf("http://%s/_lookup/%s", n.HTTPAddr(), key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var trace node.LookupTrace
+		err = json.NewDecoder(resp.Body).Decode(&trace)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner, _, err := n.Lookup(context.Background(), util.StringToID(util.HashValue(key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trace.Error != "" || trace.Owner != owner.IP {
+			t.Fatalf("%s: owner %s, want %s (%s)", key, trace.Owner, owner.IP, trace.Error)
+		}
+		if trace.Owner != n.IP && len(trace.Hops) == 0 {
+			t.Fatalf("%s: no hops recorded for a remote owner", key)
+		}
+	}
+}
+
+func TestClusterRoutingState(t *testing.T) {
+	c, err := New(3, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		put(t, c.Nodes[0], fmt.Sprintf("key-%d", i), "value")
+	}
+
+	keys := 0
+	for _, n := range c.Nodes {
+		resp, err := http.Get(fmt.Sprintf("http://%s/admin/routing", n.HTTPAddr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var st node.RoutingState
+		err = json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Node.IP != n.IP || st.Predecessor.IP != n.Predecessor().IP || st.Successors[0].IP != n.Successor().IP {
+			t.Fatalf("%s: %+v", n.IP, st)
+		}
+		// Fingers are fixed lazily, but neighboring ranges never share a node
+		next := 0
+		for i, f := range st.Fingers {
+			if f.First != next || (i > 0 && f.Node == st.Fingers[i-1].Node) {
+				t.Fatalf("%s: fingers not collapsed: %+v", n.IP, st.Fingers)
+			}
+			next = f.Last + 1
+		}
+		if next != node.KeySize {
+			t.Fatalf("%s: fingers end at %d", n.IP, next)
+		}
+		if st.Stabilize.Rounds == 0 || st.Stabilize.LastOK.IsZero() {
+			t.Fatalf("%s: %+v", n.IP, st.Stabilize)
+		}
+		keys += st.Keys
+	}
+	if keys != 10 {
+		t.Fatalf("nodes report %d keys, want 10", keys)
+	}
+
+	text := get(t, c.Nodes[0], "admin/routing?format=text")
+	if !strings.Contains(text, "fingers") || !strings.Contains(text, c.Nodes[0].IP) {
+		t.Fatalf("text form:\n%s", text)
+	}
+}
+
+func TestClusterMetrics(t *testing.T) {
+	c, err := New(3, node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+	// Successor lists fill up a round after the ring is ordered
+	err = c.WaitFor(time.Second*10, func() error {
+		if !strings.Contains(get(t, c.Nodes[0], "metrics"), "chord_successors 2\n") {
+			return errors.New("successor list not full")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		put(t, c.Nodes[0], fmt.Sprintf("key-%d", i), "value")
+	}
+
+	body := get(t, c.Nodes[0], "metrics")
+	for _, series := range []string{
+		`chord_http_requests_total{method="PUT",code="200"} 10`,
+		`chord_http_request_duration_seconds_count{method="PUT",code="200"} 10`,
+		"chord_lookup_hops_count",
+		`chord_rpc_calls_total{method="GetSuccessor",peer="`,
+		"chord_stabilize_duration_seconds_count",
+		"chord_successors 2",
+		"chord_keys ",
+		"chord_store_bytes ",
+		"chord_migrated_bytes_total 0",
+		"chord_replicated_bytes_total 0",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("missing %s", series)
+		}
+	}
+}
+
+// spanLog Collects the spans of every node of a cluster
+type spanLog struct {
+	sync.Mutex
+	spans []tracing.Span
+}
+
+func (l *spanLog) Export(s *tracing.Span) {
+	l.Lock()
+	l.spans = append(l.spans, *s)
+	l.Unlock()
+}
+
+func (l *spanLog) trace(id string) []tracing.Span {
+	l.Lock()
+	defer l.Unlock()
+	var spans []tracing.Span
+	for _, s := range l.spans {
+		if s.Trace == id {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestClusterTrace(t *testing.T) {
+	spans := &spanLog{}
+	c, err := New(3, node.Config{Trace: spans})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WaitStable(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Some keys are stored on other nodes than the one they are put to,
+	// though node 0 may own most of a ring this small
+	var remote *tracing.Trace
+	for i := 1; i <= 100 && remote == nil; i++ {
+		id := fmt.Sprintf("%032x", i)
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("http://%s/key-%d", c.Nodes[0].HTTPAddr(), i), strings.NewReader("value"))
+		req.Header.Set(tracing.Header, id)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(tracing.Header); got != id {
+			t.Fatalf("trace ID %q returned for %q", got, id)
+		}
+		if tr := tracing.Assemble(id, spans.trace(id)); len(tr.Nodes) > 1 {
+			remote = tr
+		}
+	}
+	if remote == nil {
+		t.Fatal("no traced put reached another node")
+	}
+	if len(remote.Roots) != 1 || remote.Roots[0].Name != "HTTP PUT" {
+		var b strings.Builder
+		remote.WriteText(&b)
+		t.Fatalf("trace not linked into one tree:\n%s", b.String())
+	}
+	var walk func(tr *tracing.Tree) bool
+	walk = func(tr *tracing.Tree) bool {
+		for _, ch := range tr.Children {
+			if ch.Name == "PutRemote" && tr.Name == "NodeComm.PutRemote" && ch.Node == tr.Attrs["peer"] {
+				return true
+			}
+			if walk(ch) {
+				return true
+			}
+		}
+		return false
+	}
+	if !walk(remote.Roots[0]) {
+		var b strings.Builder
+		remote.WriteText(&b)
+		t.Fatalf("no PutRemote handled under the call that sent it:\n%s", b.String())
+	}
+}
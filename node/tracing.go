@@ -0,0 +1,56 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/hoffa2/chord/comm"
+	"github.com/hoffa2/chord/tracing"
+)
+
+// traceRequests starts a trace for every request served by h, under the
+// trace ID of the tracing.Header or traceparent header if the client sent
+// one, and returns the trace ID in the tracing.Header response header
+func (n *Node) traceRequests(h http.Handler) http.Handler {
+	if n.tracer == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ctx context.Context
+		var span *tracing.Active
+		if tp := r.Header.Get("traceparent"); tp != "" {
+			ctx, span = n.tracer.Continue(r.Context(), tp, "HTTP "+r.Method)
+		}
+		if span == nil {
+			ctx, span = n.tracer.Root(r.Context(), r.Header.Get(tracing.Header), "HTTP "+r.Method)
+		}
+		span.Set("path", r.URL.Path)
+		w.Header().Set(tracing.Header, span.TraceID())
+
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		span.Set("code", strconv.Itoa(sw.code))
+		var err error
+		if sw.code >= http.StatusInternalServerError {
+			err = errHTTPStatus(sw.code)
+		}
+		span.End(err)
+	})
+}
+
+// errHTTPStatus Marks the span of a failed request
+type errHTTPStatus int
+
+func (e errHTTPStatus) Error() string {
+	return http.StatusText(int(e))
+}
+
+// traced runs the handler of an RPC within a span continuing the
+// caller's, if the call is traced
+func (n *rpcServer) traced(method string, args comm.Traced, f func(ctx context.Context) error) error {
+	ctx, span := n.tracer.Continue(n.ctx, args.Traceparent(), method)
+	err := f(ctx)
+	span.End(err)
+	return err
+}
@@ -0,0 +1,38 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// Run Runs a simulation configured from the command line and prints the report
+func Run(c *cli.Context) error {
+	cfg := Config{
+		Nodes:             c.Int("nodes"),
+		Seed:              c.Int64("seed"),
+		Duration:          c.Duration("duration"),
+		Successors:        c.Int("successors"),
+		StabilizeInterval: c.Duration("stabilize"),
+		Latency:           c.Duration("latency"),
+		Jitter:            c.Duration("jitter"),
+		LookupInterval:    c.Duration("lookups"),
+	}
+	if cfg.Nodes <= 0 {
+		cfg.Nodes = 100
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Minute * 10
+	}
+	for _, s := range c.StringSlice("churn") {
+		ev, err := ParseChurn(s)
+		if err != nil {
+			return err
+		}
+		cfg.Churn = append(cfg.Churn, ev)
+	}
+
+	fmt.Print(New(cfg).Run())
+	return nil
+}
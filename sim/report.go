@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Convergence Time the ring took to become consistent after churn
+type Convergence struct {
+	// At virtual time of the churn; 0 for the initial joins
+	At time.Duration
+	// Took time until every node had the correct successor and predecessor
+	Took time.Duration
+	// Converged false if the run ended first
+	Converged bool
+}
+
+// Report Measurements of a simulation run
+type Report struct {
+	// Duration virtual time simulated
+	Duration time.Duration
+	// Nodes live at the end of the run
+	Nodes  int
+	Joined int
+	Failed int
+	Left   int
+	// JoinErrors and LeaveErrors joins and graceful leaves that failed
+	JoinErrors  int
+	LeaveErrors int
+	// Calls between nodes
+	Calls   int
+	Lookups int
+	// FailedLookups lookups returning an error
+	FailedLookups int
+	// WrongLookups lookups returning a node not responsible for the key
+	WrongLookups int
+	// PathLengths number of successful lookups per path length
+	PathLengths map[int]int
+	// TotalLatency summed latency of all successful lookups
+	TotalLatency time.Duration
+	Convergence  []Convergence
+}
+
+// successful number of lookups returning a node
+func (r Report) successful() int {
+	return r.Lookups - r.FailedLookups
+}
+
+// MeanPathLength average number of nodes visited by a successful lookup
+func (r Report) MeanPathLength() float64 {
+	if r.successful() == 0 {
+		return 0
+	}
+	total := 0
+	for hops, cnt := range r.PathLengths {
+		total += hops * cnt
+	}
+	return float64(total) / float64(r.successful())
+}
+
+// PathLengthPercentile path length below which p percent of
+// successful lookups fall
+func (r Report) PathLengthPercentile(p float64) int {
+	lengths := make([]int, 0, len(r.PathLengths))
+	for hops := range r.PathLengths {
+		lengths = append(lengths, hops)
+	}
+	sort.Ints(lengths)
+	want := p / 100 * float64(r.successful())
+	seen := 0
+	for _, hops := range lengths {
+		seen += r.PathLengths[hops]
+		if float64(seen) >= want {
+			return hops
+		}
+	}
+	return 0
+}
+
+// MeanLatency average latency of a successful lookup
+func (r Report) MeanLatency() time.Duration {
+	if r.successful() == 0 {
+		return 0
+	}
+	return r.TotalLatency / time.Duration(r.successful())
+}
+
+func (r Report) String() string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "Simulated %s, %d nodes (joined %d, failed %d, left %d)\n",
+		r.Duration, r.Nodes, r.Joined, r.Failed, r.Left)
+	if r.JoinErrors > 0 || r.LeaveErrors > 0 {
+		fmt.Fprintf(b, "Join errors %d, leave errors %d\n", r.JoinErrors, r.LeaveErrors)
+	}
+	fmt.Fprintf(b, "Calls %d\n", r.Calls)
+	fmt.Fprintf(b, "Lookups %d, failed %d, wrong %d\n", r.Lookups, r.FailedLookups, r.WrongLookups)
+	fmt.Fprintf(b, "Path length mean %.2f, p50 %d, p99 %d\n",
+		r.MeanPathLength(), r.PathLengthPercentile(50), r.PathLengthPercentile(99))
+	fmt.Fprintf(b, "Lookup latency mean %s\n", r.MeanLatency())
+	for _, c := range r.Convergence {
+		if c.Converged {
+			fmt.Fprintf(b, "Churn at %s converged after %s\n", c.At, c.Took)
+		} else {
+			fmt.Fprintf(b, "Churn at %s did not converge\n", c.At)
+		}
+	}
+	return b.String()
+}
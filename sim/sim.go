@@ -0,0 +1,467 @@
+// Package sim runs chord rings in a deterministic discrete-event simulation.
+// Nodes execute the routing and stabilization code of package node against
+// a virtual clock and an in-memory network, so rings far larger than the
+// Rocks cluster can be studied. A run is reproducible from its seed.
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hoffa2/chord/netutils"
+	"github.com/hoffa2/chord/node"
+	"github.com/hoffa2/chord/util"
+)
+
+const (
+	// DefaultStabilizeInterval virtual period of each node's stabilize routine
+	DefaultStabilizeInterval = time.Second
+	// DefaultJoinInterval virtual time between joins of the initial nodes
+	DefaultJoinInterval = time.Millisecond * 100
+)
+
+// Config Describes one simulation run
+type Config struct {
+	// Nodes size of the initial ring
+	Nodes int
+	// Seed makes the run reproducible
+	Seed int64
+	// Duration virtual time to simulate
+	Duration time.Duration
+	// Successors length of each node's successor list
+	Successors int
+	// StabilizeInterval virtual period of each node's stabilize routine
+	StabilizeInterval time.Duration
+	// JoinInterval virtual time between joins of the initial nodes
+	JoinInterval time.Duration
+	// Latency and Jitter of one call. Calls complete within the event
+	// that issues them; their latency is accounted to lookups but
+	// does not advance the clock
+	Latency time.Duration
+	Jitter  time.Duration
+	// LookupInterval virtual time between lookups of random keys.
+	// Zero disables lookups
+	LookupInterval time.Duration
+	// CheckInterval how often the ring is checked for convergence.
+	// Defaults to StabilizeInterval
+	CheckInterval time.Duration
+	// Churn scripted joins, failures and leaves
+	Churn []ChurnEvent
+}
+
+// ChurnEvent Nodes joining, failing and leaving at a virtual time
+type ChurnEvent struct {
+	At   time.Duration
+	Join int
+	// Fail nodes crash without notifying anyone
+	Fail int
+	// Leave nodes leave gracefully
+	Leave int
+}
+
+// ParseChurn parses a churn event written as AT:JOIN:FAIL:LEAVE, e.g. 5m:100:20:0
+func ParseChurn(s string) (ChurnEvent, error) {
+	var ev ChurnEvent
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return ev, fmt.Errorf("churn %q: expected AT:JOIN:FAIL:LEAVE", s)
+	}
+	at, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return ev, err
+	}
+	ev.At = at
+	_, err = fmt.Sscanf(parts[1], "%d:%d:%d", &ev.Join, &ev.Fail, &ev.Leave)
+	if err != nil {
+		return ev, fmt.Errorf("churn %q: expected AT:JOIN:FAIL:LEAVE", s)
+	}
+	return ev, nil
+}
+
+// event An action scheduled at a virtual time. seq orders
+// events scheduled for the same time
+type event struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// simNode A node taking part in the simulation
+type simNode struct {
+	*node.Node
+	alive bool
+}
+
+// Simulator Runs nodes on a virtual clock. All events, and every
+// call they trigger, run on the caller's goroutine one at a time
+type Simulator struct {
+	cfg  Config
+	rand *rand.Rand
+	now  time.Duration
+	// Virtual time as seen by the nodes
+	clock   *util.FakeClock
+	seq     uint64
+	queue   eventQueue
+	network *netutils.MemNetwork
+	// Live nodes, and the same nodes sorted by ID; nil when stale
+	nodes  []*simNode
+	sorted []*simNode
+	// Number of addresses handed out
+	addrs int
+	// Latency accumulated by the calls of the running event
+	cost time.Duration
+	// Churn events the ring has not converged from yet
+	pending []int
+	report  Report
+}
+
+// New creates a simulator and schedules the initial joins,
+// the scripted churn, lookups and convergence checks
+func New(cfg Config) *Simulator {
+	if cfg.StabilizeInterval <= 0 {
+		cfg.StabilizeInterval = DefaultStabilizeInterval
+	}
+	if cfg.JoinInterval <= 0 {
+		cfg.JoinInterval = DefaultJoinInterval
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = cfg.StabilizeInterval
+	}
+	if cfg.Successors <= 0 {
+		cfg.Successors = node.DefaultSuccessors
+	}
+	s := &Simulator{
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		clock:   util.NewFakeClock(time.Unix(0, 0)),
+		network: netutils.NewMemNetwork(cfg.Seed),
+		report:  Report{PathLengths: make(map[int]int)},
+	}
+
+	s.churnStarted(0)
+	for i := 0; i < cfg.Nodes; i++ {
+		s.Schedule(time.Duration(i)*cfg.JoinInterval, s.join)
+	}
+	for _, ev := range cfg.Churn {
+		ev := ev
+		s.Schedule(ev.At, func() { s.churn(ev) })
+	}
+	if cfg.LookupInterval > 0 {
+		s.every(cfg.LookupInterval, s.lookup)
+	}
+	s.every(cfg.CheckInterval, s.check)
+	return s
+}
+
+// Schedule runs fn at virtual time at, or right away if at has passed
+func (s *Simulator) Schedule(at time.Duration, fn func()) {
+	if at < s.now {
+		at = s.now
+	}
+	s.seq++
+	heap.Push(&s.queue, &event{at: at, seq: s.seq, fn: fn})
+}
+
+// Runs fn every interval, starting one interval from now
+func (s *Simulator) every(interval time.Duration, fn func()) {
+	var run func()
+	run = func() {
+		fn()
+		s.Schedule(s.now+interval, run)
+	}
+	s.Schedule(s.now+interval, run)
+}
+
+// Now Returns the current virtual time
+func (s *Simulator) Now() time.Duration {
+	return s.now
+}
+
+// Step runs the next event. Returns false if no events are left
+func (s *Simulator) Step() bool {
+	if len(s.queue) == 0 {
+		return false
+	}
+	e := heap.Pop(&s.queue).(*event)
+	s.advance(e.at)
+	e.fn()
+	return true
+}
+
+// RunUntil runs all events scheduled up to and including virtual time t
+func (s *Simulator) RunUntil(t time.Duration) {
+	for len(s.queue) > 0 && s.queue[0].at <= t {
+		s.Step()
+	}
+	if s.now < t {
+		s.advance(t)
+	}
+}
+
+// Moves the virtual clock to t
+func (s *Simulator) advance(t time.Duration) {
+	s.clock.Advance(t - s.now)
+	s.now = t
+}
+
+// Run simulates cfg.Duration of virtual time, closes all nodes
+// and returns the report
+func (s *Simulator) Run() Report {
+	s.RunUntil(s.cfg.Duration)
+	r := s.Report()
+	s.Close()
+	return r
+}
+
+// Report Returns the measurements so far
+func (s *Simulator) Report() Report {
+	r := s.report
+	r.Duration = s.now
+	r.Nodes = len(s.nodes)
+	r.PathLengths = make(map[int]int, len(s.report.PathLengths))
+	for k, v := range s.report.PathLengths {
+		r.PathLengths[k] = v
+	}
+	r.Convergence = append([]Convergence(nil), s.report.Convergence...)
+	return r
+}
+
+// Nodes Returns the live nodes ordered by ID
+func (s *Simulator) Nodes() []*node.Node {
+	nodes := make([]*node.Node, 0, len(s.nodes))
+	for _, sn := range s.ring() {
+		nodes = append(nodes, sn.Node)
+	}
+	return nodes
+}
+
+// Converged reports whether every live node has the correct
+// successor and predecessor
+func (s *Simulator) Converged() bool {
+	ring := s.ring()
+	for i, sn := range ring {
+		next := ring[(i+1)%len(ring)]
+		prev := ring[(i+len(ring)-1)%len(ring)]
+		if !sn.Successor().ID.IsEqual(next.ID) ||
+			!sn.Predecessor().ID.IsEqual(prev.ID) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes all live nodes
+func (s *Simulator) Close() {
+	for _, sn := range s.nodes {
+		sn.alive = false
+		sn.Close()
+	}
+	s.nodes, s.sorted = nil, nil
+}
+
+// Live nodes sorted by ID
+func (s *Simulator) ring() []*simNode {
+	if s.sorted == nil {
+		s.sorted = append([]*simNode(nil), s.nodes...)
+		sort.Slice(s.sorted, func(i, j int) bool {
+			return s.sorted[i].ID.IsLess(s.sorted[j].ID)
+		})
+	}
+	return s.sorted
+}
+
+// The live node responsible for id
+func (s *Simulator) responsible(id util.Identifier) *simNode {
+	ring := s.ring()
+	i := sort.Search(len(ring), func(i int) bool {
+		return !ring[i].ID.IsLess(id)
+	})
+	return ring[i%len(ring)]
+}
+
+// Picks a live node at random
+func (s *Simulator) pick() *simNode {
+	return s.nodes[s.rand.Intn(len(s.nodes))]
+}
+
+// Removes sn from the live nodes
+func (s *Simulator) remove(sn *simNode) {
+	for i, t := range s.nodes {
+		if t == sn {
+			s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+			break
+		}
+	}
+	sn.alive = false
+	s.sorted = nil
+}
+
+// Records the start of a period in which the ring may be inconsistent
+func (s *Simulator) churnStarted(at time.Duration) {
+	s.pending = append(s.pending, len(s.report.Convergence))
+	s.report.Convergence = append(s.report.Convergence, Convergence{At: at})
+}
+
+func (s *Simulator) churn(ev ChurnEvent) {
+	s.churnStarted(s.now)
+	for i := 0; i < ev.Join; i++ {
+		s.join()
+	}
+	for i := 0; i < ev.Fail && len(s.nodes) > 1; i++ {
+		sn := s.pick()
+		s.remove(sn)
+		sn.Close()
+		s.report.Failed++
+	}
+	for i := 0; i < ev.Leave && len(s.nodes) > 1; i++ {
+		sn := s.pick()
+		s.remove(sn)
+		err := sn.Leave()
+		if err != nil {
+			s.report.LeaveErrors++
+		}
+		sn.Close()
+		s.report.Left++
+	}
+}
+
+// Starts a node and joins it through a random live node
+func (s *Simulator) join() {
+	s.addrs++
+	addr := fmt.Sprintf("10.%d.%d.%d:8011", s.addrs>>16&0xff, s.addrs>>8&0xff, s.addrs&0xff)
+	n, err := node.New(node.Config{
+		RPCAddr:           addr,
+		Advertise:         addr,
+		Successors:        s.cfg.Successors,
+		StabilizeInterval: s.cfg.StabilizeInterval,
+		PhiThreshold:      netutils.DefaultPhiThreshold,
+		Clock:             s.clock,
+		// A retry would wait on the virtual clock, which
+		// only moves between events
+		Retry:     netutils.RetryPolicy{Attempts: 1},
+		Log:       node.DiscardLogger(),
+		Transport: &transport{s.network.Transport(), s},
+		Seed:      s.rand.Int63(),
+		Manual:    true,
+	})
+	if err == nil {
+		err = n.Start(context.Background())
+	}
+	if err != nil {
+		s.report.JoinErrors++
+		return
+	}
+
+	bootstrap := ""
+	if len(s.nodes) > 0 {
+		bootstrap = s.pick().IP
+	}
+	err = n.Join(bootstrap)
+	if err != nil {
+		n.Close()
+		s.report.JoinErrors++
+		return
+	}
+
+	sn := &simNode{Node: n, alive: true}
+	s.nodes = append(s.nodes, sn)
+	s.sorted = nil
+	s.report.Joined++
+
+	// Random phase, so that nodes do not stabilize in lockstep
+	offset := time.Duration(s.rand.Int63n(int64(s.cfg.StabilizeInterval)))
+	var stabilize func()
+	stabilize = func() {
+		if !sn.alive {
+			return
+		}
+		sn.Stabilize()
+		s.Schedule(s.now+s.cfg.StabilizeInterval, stabilize)
+	}
+	s.Schedule(s.now+offset, stabilize)
+}
+
+// Looks up a random key from a random node and checks the answer
+func (s *Simulator) lookup() {
+	if len(s.nodes) == 0 {
+		return
+	}
+	key := make(util.Identifier, node.KeySize/8)
+	s.rand.Read(key)
+	from := s.pick()
+
+	s.cost = 0
+	succ, hops, err := from.Lookup(context.Background(), key)
+	s.report.Lookups++
+	if err != nil {
+		s.report.FailedLookups++
+		return
+	}
+	s.report.PathLengths[hops]++
+	s.report.TotalLatency += s.cost
+	if !succ.ID.IsEqual(s.responsible(key).ID) {
+		s.report.WrongLookups++
+	}
+}
+
+// Records convergence of all churn periods once the ring is correct
+func (s *Simulator) check() {
+	// The initial ring is still being built
+	if s.now < time.Duration(s.cfg.Nodes-1)*s.cfg.JoinInterval {
+		return
+	}
+	if len(s.pending) == 0 || len(s.nodes) == 0 || !s.Converged() {
+		return
+	}
+	for _, i := range s.pending {
+		c := &s.report.Convergence[i]
+		c.Converged = true
+		c.Took = s.now - c.At
+	}
+	s.pending = nil
+}
+
+// latency draws the latency of one call
+func (s *Simulator) latency() time.Duration {
+	d := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		d += time.Duration(s.rand.Int63n(int64(s.cfg.Jitter)))
+	}
+	return d
+}
+
+// transport Delivers calls over the simulator's network and
+// accounts their latency to the running event
+type transport struct {
+	*netutils.MemTransport
+	s *Simulator
+}
+
+func (t *transport) Call(ctx context.Context, addr, method string, args interface{}, reply interface{}) error {
+	t.s.report.Calls++
+	t.s.cost += t.s.latency()
+	return t.MemTransport.Call(ctx, addr, method, args, reply)
+}
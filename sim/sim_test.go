@@ -0,0 +1,113 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testConfig() Config {
+	return Config{
+		Nodes:          50,
+		Seed:           7,
+		Duration:       time.Minute * 2,
+		LookupInterval: time.Millisecond * 500,
+		Latency:        time.Millisecond * 10,
+		Jitter:         time.Millisecond * 10,
+	}
+}
+
+func TestSimulationConverges(t *testing.T) {
+	s := New(testConfig())
+	s.RunUntil(time.Minute)
+	if !s.Converged() {
+		t.Fatal("ring did not converge")
+	}
+
+	// Once converged every lookup must find the responsible node
+	before := s.Report()
+	s.RunUntil(time.Minute * 2)
+	r := s.Report()
+	s.Close()
+	if r.FailedLookups != before.FailedLookups || r.WrongLookups != before.WrongLookups {
+		t.Errorf("lookups failed on a converged ring:\n%s", r)
+	}
+	if r.Lookups == before.Lookups {
+		t.Error("no lookups were run")
+	}
+	if len(r.Convergence) != 1 || !r.Convergence[0].Converged {
+		t.Errorf("expected the initial joins to converge, got %+v", r.Convergence)
+	}
+}
+
+// Nodes joining through a node that is still its own successor, or one
+// whose fingers are not set yet, must still find their place
+func TestSimulationJoinsWithoutChurn(t *testing.T) {
+	for seed := int64(1); seed <= 4; seed++ {
+		cfg := testConfig()
+		cfg.Nodes, cfg.Seed = 100, seed
+		s := New(cfg)
+		joined := time.Duration(cfg.Nodes) * DefaultJoinInterval
+		for s.Now() < joined || !s.Converged() {
+			if s.Now() > time.Minute*5 {
+				t.Fatalf("seed %d: ring did not converge", seed)
+			}
+			s.RunUntil(s.Now() + time.Second)
+		}
+
+		before := s.Report()
+		s.RunUntil(s.Now() + time.Minute)
+		r := s.Report()
+		s.Close()
+		if r.JoinErrors != 0 || r.Joined != cfg.Nodes {
+			t.Errorf("seed %d: joins failed:\n%s", seed, r)
+		}
+		if r.WrongLookups != before.WrongLookups || r.FailedLookups != before.FailedLookups {
+			t.Errorf("seed %d: lookups failed on a converged ring:\n%s", seed, r)
+		}
+	}
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	cfg := testConfig()
+	cfg.Churn = []ChurnEvent{{At: time.Minute, Join: 5, Fail: 5, Leave: 2}}
+
+	first := New(cfg).Run()
+	second := New(cfg).Run()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("runs with the same seed differ:\n%s\n%s", first, second)
+	}
+}
+
+func TestSimulationChurn(t *testing.T) {
+	cfg := testConfig()
+	cfg.Churn = []ChurnEvent{
+		{At: time.Minute, Fail: 5},
+		{At: time.Minute + 30*time.Second, Join: 5, Leave: 3},
+	}
+	r := New(cfg).Run()
+
+	// Joins through a node with stale fingers may fail
+	if r.Failed != 5 || r.Left != 3 || r.Nodes != 42+r.Joined-cfg.Nodes {
+		t.Errorf("unexpected membership after churn:\n%s", r)
+	}
+	for _, c := range r.Convergence {
+		if !c.Converged {
+			t.Errorf("churn at %s did not converge:\n%s", c.At, r)
+		}
+	}
+}
+
+func TestParseChurn(t *testing.T) {
+	ev, err := ParseChurn("5m:100:20:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ChurnEvent{At: time.Minute * 5, Join: 100, Fail: 20, Leave: 3}
+	if ev != want {
+		t.Errorf("expected %+v, got %+v", want, ev)
+	}
+	if _, err := ParseChurn("5m:100"); err == nil {
+		t.Error("expected an error on missing counts")
+	}
+}
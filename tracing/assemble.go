@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxLine longest span line read back
+const maxLine = 1 << 20
+
+// Trace A trace put back together from the spans of several nodes
+type Trace struct {
+	ID    string
+	Spans int
+	Nodes []string
+	// Roots spans without a parent among the spans found. More than one
+	// means the spans of some hop were not found, e.g. a node's file is missing
+	Roots []*Tree
+}
+
+// Tree A span and the spans started within it, ordered by start time
+type Tree struct {
+	Span
+	Children []*Tree
+}
+
+// ReadSpans Reads the spans of trace id from JSONL files written by FileExporter
+func ReadSpans(id string, paths ...string) ([]Span, error) {
+	var spans []Span
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		spans, err = readSpans(f, id, spans)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return spans, nil
+}
+
+func readSpans(r io.Reader, id string, spans []Span) ([]Span, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxLine)
+	for line := 1; sc.Scan(); line++ {
+		// Cheap filter before decoding every span of the file
+		if !strings.Contains(sc.Text(), id) {
+			continue
+		}
+		var s Span
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if s.Trace == id {
+			spans = append(spans, s)
+		}
+	}
+	return spans, sc.Err()
+}
+
+// Assemble Links spans to their parents
+func Assemble(id string, spans []Span) *Trace {
+	t := &Trace{ID: id, Spans: len(spans)}
+	trees := make(map[string]*Tree, len(spans))
+	nodes := make(map[string]bool)
+	for i := range spans {
+		trees[spans[i].ID] = &Tree{Span: spans[i]}
+		if !nodes[spans[i].Node] {
+			nodes[spans[i].Node] = true
+			t.Nodes = append(t.Nodes, spans[i].Node)
+		}
+	}
+	sort.Strings(t.Nodes)
+	for i := range spans {
+		tr := trees[spans[i].ID]
+		if parent, ok := trees[tr.Parent]; ok && tr.Parent != "" {
+			parent.Children = append(parent.Children, tr)
+		} else {
+			t.Roots = append(t.Roots, tr)
+		}
+	}
+	for _, tr := range trees {
+		sortByStart(tr.Children)
+	}
+	sortByStart(t.Roots)
+	return t
+}
+
+func sortByStart(trees []*Tree) {
+	sort.SliceStable(trees, func(i, j int) bool {
+		return trees[i].Start.Before(trees[j].Start)
+	})
+}
+
+// WriteText Prints the trace as an indented tree. Offsets are relative to
+// the start of the first root; clocks of different nodes may disagree
+func (t *Trace) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "trace %s: %d spans on %d nodes\n", t.ID, t.Spans, len(t.Nodes))
+	if len(t.Roots) == 0 {
+		return
+	}
+	if len(t.Roots) > 1 {
+		fmt.Fprintf(w, "%d spans without a known parent; the trace is incomplete\n", len(t.Roots)-1)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OFFSET\tDURATION\tNODE\tSPAN\tERROR")
+	began := t.Roots[0].Start
+	for _, r := range t.Roots {
+		r.write(tw, began, 0)
+	}
+	tw.Flush()
+}
+
+func (tr *Tree) write(w io.Writer, began time.Time, depth int) {
+	name := tr.Name
+	if peer := tr.Attrs["peer"]; peer != "" {
+		name += " -> " + peer
+	}
+	fmt.Fprintf(w, "+%v\t%v\t%s\t%s%s\t%s\n", tr.Start.Sub(began), tr.Duration,
+		tr.Node, strings.Repeat("  ", depth), name, tr.Error)
+	for _, c := range tr.Children {
+		c.write(w, began, depth+1)
+	}
+}
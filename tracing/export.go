@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// FormatJSONL One Span per line, as read by Assemble
+	FormatJSONL = "jsonl"
+	// FormatOTLP One OpenTelemetry ExportTraceServiceRequest per line,
+	// in the OTLP/JSON encoding, for collectors that read files
+	FormatOTLP = "otlp"
+)
+
+// FileExporter Appends spans to a file, one per line
+type FileExporter struct {
+	mu     sync.Mutex
+	f      io.WriteCloser
+	format string
+	enc    *json.Encoder
+}
+
+// NewFileExporter opens path for appending spans in format
+func NewFileExporter(path, format string) (*FileExporter, error) {
+	switch format {
+	case "":
+		format = FormatJSONL
+	case FormatJSONL, FormatOTLP:
+	default:
+		return nil, fmt.Errorf("unknown trace format %q, expected %s or %s", format, FormatJSONL, FormatOTLP)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, format: format, enc: json.NewEncoder(f)}, nil
+}
+
+// Export writes s. Spans that cannot be written are dropped
+func (e *FileExporter) Export(s *Span) {
+	var v interface{} = s
+	if e.format == FormatOTLP {
+		v = otlpRequest(s)
+	}
+	e.mu.Lock()
+	e.enc.Encode(v)
+	e.mu.Unlock()
+}
+
+// Close closes the file
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
+
+// The subset of the OTLP/JSON encoding spans are written in. IDs are
+// hex and times are decimal strings of nanoseconds since the epoch
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpKinds SpanKind values of the kinds of spans
+var otlpKinds = map[string]int{Internal: 1, Server: 2, Client: 3}
+
+func otlpAttr(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+// otlpRequest Wraps s in an export request whose resource is s's node
+func otlpRequest(s *Span) *otlpExport {
+	o := otlpSpan{
+		TraceID:           s.Trace,
+		SpanID:            s.ID,
+		ParentSpanID:      s.Parent,
+		Name:              s.Name,
+		Kind:              otlpKinds[s.Kind],
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.Start.Add(s.Duration).UnixNano(), 10),
+	}
+	keys := make([]string, 0, len(s.Attrs))
+	for k := range s.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.Attributes = append(o.Attributes, otlpAttr(k, s.Attrs[k]))
+	}
+	if s.Error != "" {
+		// STATUS_CODE_ERROR
+		o.Status = otlpStatus{Code: 2, Message: s.Error}
+	}
+	return &otlpExport{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpAttr("service.name", "chord"),
+			otlpAttr("service.instance.id", s.Node),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/hoffa2/chord"},
+			Spans: []otlpSpan{o},
+		}},
+	}}}
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+)
+
+// Run Assembles the trace given as first argument from the span files
+// given after it, or from the *.jsonl files in --dir, and prints it
+func Run(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("usage: trace <id> [files...]")
+	}
+	id := c.Args().First()
+	if !traceID.MatchString(id) {
+		return fmt.Errorf("%q is not a trace ID: expected 32 hex digits", id)
+	}
+	paths := c.Args().Tail()
+	if len(paths) == 0 {
+		dir := c.String("dir")
+		if dir == "" {
+			dir = "."
+		}
+		var err error
+		if paths, err = filepath.Glob(filepath.Join(dir, "*.jsonl")); err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no span files in %s", dir)
+		}
+	}
+	spans, err := ReadSpans(id, paths...)
+	if err != nil {
+		return err
+	}
+	if len(spans) == 0 {
+		return fmt.Errorf("trace %s not found in %d files", id, len(paths))
+	}
+	Assemble(id, spans).WriteText(os.Stdout)
+	return nil
+}
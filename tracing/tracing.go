@@ -0,0 +1,210 @@
+// Package tracing follows requests across the nodes they pass through.
+// A trace is started for an HTTP request, its context travels in the
+// arguments of every RPC made on its behalf, and each node exports the
+// spans it records, see FileExporter. Assemble puts a trace back together
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+// Header accepted and returned by the HTTP API with the trace ID of a request
+const Header = "X-Chord-Trace"
+
+var (
+	traceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanID  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// Kinds of spans
+const (
+	// Server spans handle a request: an HTTP request or an RPC from a peer
+	Server = "server"
+	// Client spans time an RPC to a peer
+	Client = "client"
+	// Internal spans time work within a node
+	Internal = "internal"
+)
+
+// Span One timed operation of a trace on one node
+type Span struct {
+	Trace  string
+	ID     string
+	Parent string `json:",omitempty"`
+	Node   string
+	Name   string
+	Kind   string
+	Start  time.Time
+	// Duration in nanoseconds
+	Duration time.Duration
+	Attrs    map[string]string `json:",omitempty"`
+	Error    string            `json:",omitempty"`
+}
+
+// Exporter Receives finished spans
+type Exporter interface {
+	Export(s *Span)
+}
+
+// Tracer Records the spans of one node. A nil Tracer records nothing
+type Tracer struct {
+	clock    util.Clock
+	exporter Exporter
+	mu       sync.Mutex
+	node     string
+}
+
+// NewTracer creates a tracer exporting spans of node to e
+func NewTracer(node string, clock util.Clock, e Exporter) *Tracer {
+	if clock == nil {
+		clock = util.RealClock{}
+	}
+	return &Tracer{node: node, clock: clock, exporter: e}
+}
+
+// SetNode changes the node name spans are recorded under, e.g. once
+// the address a node listens on is known
+func (t *Tracer) SetNode(node string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.node = node
+	t.mu.Unlock()
+}
+
+type spanKey struct{}
+
+// spanContext Identifies the active span of a context
+type spanContext struct {
+	trace string
+	span  string
+}
+
+// Root Starts the first span of a trace. An invalid or empty id starts a new trace
+func (t *Tracer) Root(ctx context.Context, id, name string) (context.Context, *Active) {
+	if t == nil {
+		return ctx, nil
+	}
+	if !traceID.MatchString(id) {
+		id = newID(16)
+	}
+	return t.start(ctx, spanContext{trace: id}, Server, name)
+}
+
+// Start Starts a child of the span active in ctx. Returns a nil span
+// if ctx is not traced
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Active) {
+	parent, ok := ctx.Value(spanKey{}).(spanContext)
+	if t == nil || !ok {
+		return ctx, nil
+	}
+	return t.start(ctx, parent, Internal, name)
+}
+
+// StartCall Starts a client span for an RPC to peer, as a child of the
+// span active in ctx. Returns a nil span if ctx is not traced
+func (t *Tracer) StartCall(ctx context.Context, method, peer string) *Active {
+	parent, ok := ctx.Value(spanKey{}).(spanContext)
+	if t == nil || !ok {
+		return nil
+	}
+	_, a := t.start(ctx, parent, Client, method)
+	a.Set("peer", peer)
+	return a
+}
+
+// Continue Starts a span as a child of the remote span described by
+// traceparent, as carried in RPC arguments. Returns a nil span if
+// traceparent is empty or invalid
+func (t *Tracer) Continue(ctx context.Context, traceparent, name string) (context.Context, *Active) {
+	parent, ok := parseTraceparent(traceparent)
+	if t == nil || !ok {
+		return ctx, nil
+	}
+	return t.start(ctx, parent, Server, name)
+}
+
+func (t *Tracer) start(ctx context.Context, parent spanContext, kind, name string) (context.Context, *Active) {
+	t.mu.Lock()
+	node := t.node
+	t.mu.Unlock()
+	a := &Active{t: t, span: Span{
+		Trace:  parent.trace,
+		ID:     newID(8),
+		Parent: parent.span,
+		Node:   node,
+		Name:   name,
+		Kind:   kind,
+		Start:  t.clock.Now(),
+	}}
+	return context.WithValue(ctx, spanKey{}, spanContext{trace: a.span.Trace, span: a.span.ID}), a
+}
+
+// Active A span that has not ended yet. Methods of a nil Active do nothing
+type Active struct {
+	t    *Tracer
+	span Span
+}
+
+// TraceID Returns the ID of the span's trace
+func (a *Active) TraceID() string {
+	if a == nil {
+		return ""
+	}
+	return a.span.Trace
+}
+
+// Traceparent Returns the W3C traceparent header value identifying the span
+func (a *Active) Traceparent() string {
+	if a == nil {
+		return ""
+	}
+	return "00-" + a.span.Trace + "-" + a.span.ID + "-01"
+}
+
+// Set attaches an attribute to the span
+func (a *Active) Set(key, value string) {
+	if a == nil {
+		return
+	}
+	if a.span.Attrs == nil {
+		a.span.Attrs = make(map[string]string)
+	}
+	a.span.Attrs[key] = value
+}
+
+// End Ends the span and exports it. err marks the span as failed
+func (a *Active) End(err error) {
+	if a == nil {
+		return
+	}
+	a.span.Duration = a.t.clock.Now().Sub(a.span.Start)
+	if err != nil {
+		a.span.Error = err.Error()
+	}
+	a.t.exporter.Export(&a.span)
+}
+
+// parseTraceparent Parses a W3C traceparent: 00-<trace id>-<span id>-<flags>
+func parseTraceparent(s string) (spanContext, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || !traceID.MatchString(parts[1]) || !spanID.MatchString(parts[2]) {
+		return spanContext{}, false
+	}
+	return spanContext{trace: parts[1], span: parts[2]}, true
+}
+
+func newID(bytes int) string {
+	b := make([]byte, bytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
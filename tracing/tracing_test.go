@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoffa2/chord/util"
+)
+
+type memExporter []Span
+
+func (m *memExporter) Export(s *Span) { *m = append(*m, *s) }
+
+func TestPropagation(t *testing.T) {
+	clock := util.NewFakeClock(time.Unix(0, 0))
+	var spans memExporter
+	a := NewTracer("a", clock, &spans)
+	b := NewTracer("b", clock, &spans)
+
+	id := strings.Repeat("ab", 16)
+	ctx, root := a.Root(context.Background(), id, "HTTP PUT")
+	call := a.StartCall(ctx, "NodeComm.PutRemote", "b")
+	clock.Advance(time.Millisecond)
+	_, handler := b.Continue(context.Background(), call.Traceparent(), "PutRemote")
+	clock.Advance(time.Millisecond)
+	handler.End(errors.New("no space"))
+	call.End(nil)
+	root.End(nil)
+
+	// Untraced contexts and invalid traceparents start no spans
+	if _, s := a.Start(context.Background(), "lookup"); s != nil {
+		t.Fatal("span started without a trace")
+	}
+	if _, s := b.Continue(context.Background(), "00-xyz-01", "PutRemote"); s != nil {
+		t.Fatal("span continued from an invalid traceparent")
+	}
+
+	tr := Assemble(id, spans)
+	if len(tr.Roots) != 1 || len(tr.Nodes) != 2 {
+		t.Fatalf("got %d roots on %v", len(tr.Roots), tr.Nodes)
+	}
+	r := tr.Roots[0]
+	if r.Name != "HTTP PUT" || len(r.Children) != 1 || len(r.Children[0].Children) != 1 {
+		t.Fatalf("unexpected tree %+v", r)
+	}
+	h := r.Children[0].Children[0]
+	if h.Node != "b" || h.Kind != Server || h.Duration != time.Millisecond || h.Error != "no space" {
+		t.Fatalf("unexpected handler span %+v", h.Span)
+	}
+	var out bytes.Buffer
+	tr.WriteText(&out)
+	if !strings.Contains(out.String(), "    PutRemote") || !strings.Contains(out.String(), "NodeComm.PutRemote -> b") {
+		t.Fatalf("got:\n%s", out.String())
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir := t.TempDir()
+	id := strings.Repeat("cd", 16)
+	for _, format := range []string{FormatJSONL, FormatOTLP} {
+		clock := util.NewFakeClock(time.Unix(1, 0))
+		e, err := NewFileExporter(filepath.Join(dir, "a."+format), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := NewTracer("a", clock, e)
+		_, s := tr.Root(context.Background(), id, "HTTP GET")
+		s.Set("path", "/key")
+		clock.Advance(time.Second)
+		s.End(nil)
+		e.Close()
+	}
+
+	spans, err := ReadSpans(id, filepath.Join(dir, "a.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 1 || spans[0].Attrs["path"] != "/key" || spans[0].Duration != time.Second {
+		t.Fatalf("got %+v", spans)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "a.otlp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req otlpExport
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	o := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if o.TraceID != id || o.Kind != 2 || o.StartTimeUnixNano != "1000000000" || o.EndTimeUnixNano != "2000000000" {
+		t.Fatalf("got %+v", o)
+	}
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock Source of time for timers and periodic routines,
+// so that tests can control how time passes
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// RealClock Clock backed by package time
+type RealClock struct{}
+
+// Now Returns the wall time
+func (RealClock) Now() time.Time { return time.Now() }
+
+// After Wraps time.After
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Sleep Wraps time.Sleep
+func (RealClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// fakeTimer A pending After or Sleep on a FakeClock
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+	// Advances the clock had seen when the timer was created
+	round int
+}
+
+// FakeClock Clock that only moves when advanced. Timers fire
+// from Advance, in the order of their deadlines
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+	// Number of calls to Advance
+	round int
+}
+
+// NewFakeClock creates a clock standing still at now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now Returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After Fires once the clock has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, &fakeTimer{at: c.now.Add(d), ch: ch, round: c.round})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Sleep Blocks until the clock has been advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock forward by d and fires all timers due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.round++
+
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+	fired := 0
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			break
+		}
+		t.ch <- t.at
+		fired++
+	}
+	c.timers = append([]*fakeTimer(nil), c.timers[fired:]...)
+}
+
+// Waiters Returns the number of pending timers
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers created since the last
+// Advance are pending. Lets a test advance the clock only once its
+// routines are waiting on it again. Timers left over from earlier
+// rounds, such as those whose caller stopped waiting for them, do
+// not count
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.armed() < n {
+		c.cond.Wait()
+	}
+}
+
+// armed Returns the number of timers created since the last Advance
+func (c *FakeClock) armed() int {
+	n := 0
+	for _, t := range c.timers {
+		if t.round == c.round {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockAfter(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewFakeClock(start)
+	late := c.After(time.Second * 2)
+	early := c.After(time.Second)
+
+	c.Advance(time.Millisecond * 500)
+	select {
+	case <-early:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+
+	c.Advance(time.Millisecond * 500)
+	if at := <-early; !at.Equal(start.Add(time.Second)) {
+		t.Errorf("expected timer to fire at 1s, got %s", at.Sub(start))
+	}
+	if c.Waiters() != 1 {
+		t.Errorf("expected one pending timer, got %d", c.Waiters())
+	}
+
+	c.Advance(time.Second * 5)
+	<-late
+	if now := c.Now(); !now.Equal(start.Add(time.Second * 6)) {
+		t.Errorf("expected clock at 6s, got %s", now.Sub(start))
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Minute)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-done
+}
+
+func TestFakeClockBlockUntilIgnoresEarlierRounds(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	// Abandoned by its caller, still pending after the next Advance
+	c.After(time.Hour)
+	c.Advance(time.Second)
+
+	returned := make(chan struct{})
+	go func() {
+		c.BlockUntil(1)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+		t.Fatal("BlockUntil counted a timer from an earlier round")
+	case <-time.After(time.Millisecond * 50):
+	}
+	go c.Sleep(time.Minute)
+	<-returned
+}
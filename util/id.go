@@ -2,6 +2,8 @@ package util
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"math/big"
 )
 
@@ -33,6 +35,21 @@ func StringToID(str string) Identifier {
 	return n
 }
 
+// HexToID parses a hex encoded identifier of at most size bytes.
+// Shorter identifiers are padded with leading zeros
+func HexToID(str string, size int) (Identifier, error) {
+	b, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > size {
+		return nil, fmt.Errorf("identifier %s is longer than %d bytes", str, size)
+	}
+	id := make(Identifier, size)
+	copy(id[size-len(b):], b)
+	return id, nil
+}
+
 // InKeySpace asserts whether nodeID is in
 // the keyspace between one and two.
 func (id Identifier) InKeySpace(one, two Identifier) bool {
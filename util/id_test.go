@@ -25,6 +25,6 @@ func TestNodeIDKeySpace(t *testing.T) {
 
 func TestMod(t *testing.T) {
 	one := Identifier([]byte{1})
-	res := one.CalculateStart(2, 160)
+	res := one.PowMod(2, 160)
 	fmt.Println(res)
 }
@@ -14,7 +14,7 @@ func ErrorResponse(w http.ResponseWriter, err string) {
 }
 
 func ErrorNotFound(w http.ResponseWriter, format string, a ...interface{}) {
-	errString := fmt.Sprintf(format, a)
+	errString := fmt.Sprintf(format, a...)
 	http.Error(w, errString, http.StatusNotFound)
 }
 